@@ -2,6 +2,7 @@ package main
 
 import (
 	"docker/internal/runc/cmd"
+	"docker/internal/utils/config"
 	"os"
 
 	log "github.com/Sirupsen/logrus"
@@ -19,21 +20,72 @@ func createApp() *cli.App {
 	app.Name = name
 	app.Usage = usage
 
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "location of the config file",
+			Value:  config.DefaultConfigFile,
+			EnvVar: "MINIDOCKER_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "root",
+			Usage:  "root directory of the runtime state (default: " + config.DefaultRoot + ")",
+			EnvVar: "MINIDOCKER_ROOT",
+		},
+		cli.StringFlag{
+			Name:   "data-root",
+			Usage:  "root directory of the persistent data (default: " + config.DefaultDataRoot + ")",
+			EnvVar: "MINIDOCKER_DATA_ROOT",
+		},
+		cli.StringFlag{
+			Name:   "storage-driver",
+			Usage:  "storage driver of the container rootfs, overlay or vfs (default: overlay if supported)",
+			EnvVar: "MINIDOCKER_STORAGE_DRIVER",
+		},
+	}
+
 	app.Commands = []cli.Command{
 		cmd.InitCommand,
 		cmd.RunCommand,
 		cmd.CommitCommand,
 		cmd.ListCommand,
+		cmd.InspectCommand,
+		cmd.DiffCommand,
+		cmd.CopyCommand,
 		cmd.LogCommand,
 		cmd.StopCommand,
 		cmd.RemoveCommand,
 		cmd.ExecCommand,
 		cmd.NetworkCommand,
+		cmd.ImagesCommand,
+		cmd.ImportCommand,
+		cmd.ExportCommand,
+		cmd.LoadCommand,
+		cmd.SaveCommand,
+		cmd.PullCommand,
+		cmd.PushCommand,
+		cmd.LoginCommand,
+		cmd.BuildCommand,
+		cmd.BuilderCommand,
+		cmd.RemoveImageCommand,
+		cmd.TagCommand,
+		cmd.HistoryCommand,
+		cmd.ImageCommand,
+		cmd.VolumeCommand,
 	}
 
 	app.Before = func(ctx *cli.Context) error {
 		log.SetFormatter(&log.JSONFormatter{})
 		log.SetOutput(os.Stdout)
+
+		// flags and environment variables take precedence over the config file
+		if err := config.Load(ctx.GlobalString("config")); err != nil {
+			return err
+		}
+		config.SetRoot(ctx.GlobalString("root"))
+		config.SetDataRoot(ctx.GlobalString("data-root"))
+		config.SetStorageDriver(ctx.GlobalString("storage-driver"))
+
 		return nil
 	}
 
@@ -5,8 +5,11 @@ go 1.19
 require (
 	github.com/Sirupsen/logrus v0.8.8-0.20151204141443-446d1c146faa
 	github.com/gosuri/uitable v0.0.4
+	github.com/klauspost/compress v1.16.7
 	github.com/urfave/cli v1.22.13
 	github.com/vishvananda/netlink v1.1.0
+	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df
+	golang.org/x/sys v0.6.0
 )
 
 require (
@@ -17,6 +20,4 @@ require (
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/vishvananda/netns v0.0.0-20191106174202-0a2b9b5464df // indirect
-	golang.org/x/sys v0.6.0 // indirect
 )
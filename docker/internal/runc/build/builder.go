@@ -0,0 +1,377 @@
+package build
+
+import (
+	"docker/internal/runc/image"
+	"docker/internal/runc/registry"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Options struct {
+	ContextDir string
+	Dockerfile string
+	Tags       []string
+	NoCache    bool
+	// Target is the stage the build stops at, the last stage by default.
+	Target string
+}
+
+// stage is the part of a multi-stage Dockerfile which starts at a FROM,
+// `FROM image AS name` names the stage for FROM and COPY --from.
+type stage struct {
+	name         string
+	base         string
+	instructions []*Instruction
+	image        *image.Image
+	needed       bool
+}
+
+// Executor runs a RUN instruction in a throwaway container of the image, the
+// diff of the container is passed to commit as the new layer and the
+// container is removed afterwards.
+type Executor interface {
+	Run(imageID string, command []string, commit func(diff io.Reader) error) error
+}
+
+type Builder struct {
+	options  *Options
+	executor Executor
+	ignore   *ignoreMatcher
+	cache    *buildCache
+	// key is the cache key of the current step.
+	key    string
+	stages []*stage
+	// current is the index of the stage being built.
+	current int
+	// image is the result of the last instruction, nil for FROM scratch.
+	image  *image.Image
+	config image.Config
+	// cmdSet records a CMD of this build, ENTRYPOINT resets an inherited CMD.
+	cmdSet bool
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+
+	return id
+}
+
+func (b *Builder) imageID() string {
+	if b.image == nil {
+		return ""
+	}
+
+	return b.image.ID
+}
+
+func (b *Builder) workdir() string {
+	if b.config.WorkingDir == "" {
+		return "/"
+	}
+
+	return b.config.WorkingDir
+}
+
+// expand replaces $VAR and ${VAR} with the environment of the image.
+func (b *Builder) expand(value string) string {
+	return os.Expand(value, func(key string) string {
+		for _, env := range b.config.Env {
+			if k, v, _ := strings.Cut(env, "="); k == key {
+				return v
+			}
+		}
+		return ""
+	})
+}
+
+func (b *Builder) setImage(img *image.Image) {
+	b.image = img
+	if img != nil {
+		b.config = img.Config.Copy()
+	}
+}
+
+func (b *Builder) commit(diff io.Reader, instruction *Instruction) error {
+	createdBy := instruction.Original
+	if instruction.Command == "RUN" {
+		if _, ok := instruction.jsonArgs(); !ok {
+			createdBy = "/bin/sh -c " + instruction.Args
+		}
+	}
+
+	img, err := image.Commit(b.imageID(), diff, "", &image.CommitOptions{CreatedBy: createdBy})
+	if err != nil {
+		return err
+	}
+
+	b.setImage(img)
+	return b.cache.add(b.key, img.ID)
+}
+
+// commitConfig records an instruction which only changes the config.
+func (b *Builder) commitConfig(config image.Config, instruction *Instruction) error {
+	img, err := image.CommitConfig(b.imageID(), &image.CommitOptions{
+		Config:    &config,
+		CreatedBy: "/bin/sh -c #(nop) " + instruction.Original,
+	})
+	if err != nil {
+		return err
+	}
+
+	b.setImage(img)
+	return b.cache.add(b.key, img.ID)
+}
+
+// stageIndex finds a stage by its name or its index.
+func stageIndex(stages []*stage, name string) int {
+	for i, st := range stages {
+		if (st.name != "" && st.name == strings.ToLower(name)) || strconv.Itoa(i) == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// stageImage returns the image of a previous stage or an image, which is
+// pulled if it is not in the store.
+func (b *Builder) stageImage(name string) (*image.Image, error) {
+	if i := stageIndex(b.stages[:b.current], name); i >= 0 {
+		return b.stages[i].image, nil
+	}
+
+	if img, err := image.Lookup(name); err == nil {
+		return img, nil
+	}
+
+	return registry.Pull(name)
+}
+
+func (b *Builder) from(instruction *Instruction) error {
+	b.image, b.config, b.cmdSet = nil, image.Config{}, false
+
+	base := b.stages[b.current].base
+	if base == "scratch" {
+		return nil
+	}
+
+	img, err := b.stageImage(base)
+	if err != nil {
+		return err
+	}
+
+	b.setImage(img)
+	return nil
+}
+
+func (b *Builder) run(instruction *Instruction) error {
+	if b.image == nil {
+		return fmt.Errorf("minidocker: RUN requires a base image")
+	}
+
+	return b.executor.Run(b.image.ID, instruction.command(), func(diff io.Reader) error {
+		return b.commit(diff, instruction)
+	})
+}
+
+// change applies the instructions which are handled by --change of commit.
+func (b *Builder) change(instruction *Instruction) error {
+	config := b.config.Copy()
+
+	value := instruction.Args
+	switch instruction.Command {
+	case "CMD":
+		// the command is expanded by the shell at run
+	case "ENTRYPOINT":
+		if !b.cmdSet {
+			config.Cmd = nil
+		}
+	case "WORKDIR":
+		value = b.expand(value)
+		if !path.IsAbs(value) {
+			value = path.Join(b.workdir(), value)
+		}
+	default:
+		value = b.expand(value)
+	}
+
+	if err := config.ApplyChange(instruction.Command + " " + value); err != nil {
+		return err
+	}
+
+	return b.commitConfig(config, instruction)
+}
+
+func (b *Builder) dispatch(instruction *Instruction) error {
+	switch instruction.Command {
+	case "FROM":
+		return b.from(instruction)
+	case "COPY", "ADD":
+		return b.copy(instruction)
+	case "RUN":
+		if b.probeCache(instruction, "") {
+			return nil
+		}
+		return b.run(instruction)
+	case "ENV", "WORKDIR", "USER", "CMD", "ENTRYPOINT", "EXPOSE", "LABEL":
+		if instruction.Command == "CMD" {
+			b.cmdSet = true
+		}
+		if b.probeCache(instruction, "") {
+			return nil
+		}
+		return b.change(instruction)
+	}
+
+	return fmt.Errorf("minidocker: unknown instruction %v", instruction.Command)
+}
+
+// parseStages splits the instructions into stages and marks the stages the
+// target depends on through FROM and COPY --from, other stages are skipped.
+func parseStages(instructions []*Instruction, target string) ([]*stage, error) {
+	if instructions[0].Command != "FROM" {
+		return nil, fmt.Errorf("minidocker: the Dockerfile must start with FROM")
+	}
+
+	var stages []*stage
+	for _, instruction := range instructions {
+		if instruction.Command == "FROM" {
+			fields := strings.Fields(instruction.Args)
+			st := &stage{base: fields[0]}
+			if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+				st.name = strings.ToLower(fields[2])
+			} else if len(fields) != 1 {
+				return nil, fmt.Errorf("minidocker: line %d: invalid FROM %v", instruction.Line, instruction.Args)
+			}
+			stages = append(stages, st)
+		}
+		st := stages[len(stages)-1]
+		st.instructions = append(st.instructions, instruction)
+	}
+
+	last := len(stages) - 1
+	if target != "" {
+		if last = stageIndex(stages, target); last < 0 {
+			return nil, fmt.Errorf("minidocker: target stage %v not found", target)
+		}
+	}
+	stages = stages[:last+1]
+
+	var mark func(i int)
+	mark = func(i int) {
+		if stages[i].needed {
+			return
+		}
+		stages[i].needed = true
+
+		if dep := stageIndex(stages[:i], stages[i].base); dep >= 0 {
+			mark(dep)
+		}
+		for _, instruction := range stages[i].instructions {
+			if from, ok := instruction.Flags["from"]; ok {
+				if dep := stageIndex(stages[:i], from); dep >= 0 {
+					mark(dep)
+				}
+			}
+		}
+	}
+	mark(last)
+
+	return stages, nil
+}
+
+// Build runs the instructions of the Dockerfile one by one, each of them
+// creates a new image on top of the previous one.
+func Build(options *Options, executor Executor) (*image.Image, error) {
+	for _, tag := range options.Tags {
+		if _, _, err := image.ParseReference(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	contextDir, err := filepath.Abs(options.ContextDir)
+	if err != nil {
+		return nil, err
+	}
+	options.ContextDir = contextDir
+
+	dockerfile := options.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+
+	file, err := os.Open(dockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: open Dockerfile failed [%v]", err)
+	}
+	defer file.Close()
+
+	instructions, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := parseStages(instructions, options.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	ignore, err := loadIgnore(contextDir)
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: read .dockerignore failed [%v]", err)
+	}
+
+	cache, err := loadCache()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Builder{options: options, executor: executor, ignore: ignore, cache: cache, stages: stages}
+
+	steps := 0
+	for _, st := range stages {
+		if st.needed {
+			steps += len(st.instructions)
+		}
+	}
+
+	step := 0
+	for i, st := range stages {
+		if !st.needed {
+			continue
+		}
+
+		b.current = i
+		for _, instruction := range st.instructions {
+			step++
+			fmt.Printf("Step %d/%d : %s\n", step, steps, instruction.Original)
+			if err := b.dispatch(instruction); err != nil {
+				return nil, fmt.Errorf("minidocker: step %d at line %d failed [%v]", step, instruction.Line, err)
+			}
+			if b.image != nil {
+				fmt.Printf(" ---> %s\n", shortID(b.image.ID))
+			}
+		}
+		st.image = b.image
+	}
+
+	if b.image == nil {
+		return nil, fmt.Errorf("minidocker: the build produced no image")
+	}
+
+	fmt.Printf("Successfully built %s\n", shortID(b.image.ID))
+	for _, tag := range options.Tags {
+		if err := image.Tag(b.image, tag); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Successfully tagged %s\n", tag)
+	}
+
+	return b.image, nil
+}
@@ -0,0 +1,232 @@
+package build
+
+import (
+	"crypto/sha256"
+	"docker/internal/runc/container"
+	"docker/internal/runc/image"
+	"docker/internal/utils/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const cacheName = "cache.json"
+
+// buildCache maps the key of a build step to the image it produced, the key
+// is derived from the parent image, the instruction and the copied content.
+// Images records every image of the builder, including those whose entry was
+// replaced by a build with --no-cache.
+type buildCache struct {
+	Entries map[string]string `json:"entries"`
+	Images  []string          `json:"images"`
+}
+
+func cachePath() string {
+	return config.DataPath("build", cacheName)
+}
+
+func loadCache() (*buildCache, error) {
+	cache := &buildCache{Entries: map[string]string{}}
+
+	content, err := os.ReadFile(cachePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, cache); err != nil {
+		return nil, fmt.Errorf("minidocker: parse build cache failed [%v]", err)
+	}
+	if cache.Entries == nil {
+		cache.Entries = map[string]string{}
+	}
+
+	return cache, nil
+}
+
+func (cache *buildCache) dump() error {
+	content, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath()), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(cachePath(), content, 0644)
+}
+
+func cacheKey(parentID, instruction, contentHash string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s", parentID, instruction, contentHash)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup returns the cached image of the key if it is still in the store.
+func (cache *buildCache) lookup(key string) *image.Image {
+	id, exist := cache.Entries[key]
+	if !exist {
+		return nil
+	}
+
+	img, err := image.Lookup(id)
+	if err != nil || img.ID != id {
+		return nil
+	}
+
+	return img
+}
+
+func (cache *buildCache) add(key, id string) error {
+	cache.Entries[key] = id
+	for _, image := range cache.Images {
+		if image == id {
+			return cache.dump()
+		}
+	}
+	cache.Images = append(cache.Images, id)
+
+	return cache.dump()
+}
+
+// hashSources hashes the names, modes and content of the COPY and ADD
+// sources, files excluded by .dockerignore are not part of the hash.
+func hashSources(root *sourceRoot, sources []string) (string, error) {
+	h := sha256.New()
+
+	for _, src := range sources {
+		err := root.walk(src, func(file, rel string, info os.FileInfo) error {
+			fmt.Fprintf(h, "%s\x00%s\x00%o\x00", filepath.Base(src), filepath.ToSlash(rel), info.Mode())
+
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(file)
+				if err != nil {
+					return err
+				}
+				io.WriteString(h, link)
+			case info.Mode().IsRegular():
+				f, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if _, err := io.Copy(h, f); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// probeCache reuses the cached image of the instruction, the key is kept so
+// the image of the step is recorded in the cache on a miss.
+func (b *Builder) probeCache(instruction *Instruction, contentHash string) bool {
+	b.key = cacheKey(b.imageID(), instruction.Original, contentHash)
+	if b.options.NoCache {
+		return false
+	}
+
+	img := b.cache.lookup(b.key)
+	if img == nil {
+		return false
+	}
+
+	fmt.Println(" ---> CACHED")
+	b.setImage(img)
+	return true
+}
+
+// Prune removes the cached images which are not tagged, used by a container
+// or the parent of another image, followed by the layers no image refers to.
+// The entries of the images kept stay in the cache.
+func Prune() (int64, error) {
+	cache, err := loadCache()
+	if err != nil {
+		return 0, err
+	}
+
+	refs, err := container.ImageReferences()
+	if err != nil {
+		return 0, err
+	}
+
+	// a child and its parent may both be cached, the parent is deleted in
+	// the next round
+	pending := cache.Images
+	for {
+		counts, err := image.ChildCounts()
+		if err != nil {
+			return 0, err
+		}
+
+		var kept []string
+		for _, id := range pending {
+			img, err := image.Lookup(id)
+			if err != nil || img.ID != id || len(img.RepoTags) != 0 || len(refs.Containers[id]) != 0 {
+				continue
+			}
+			if counts[id] != 0 {
+				kept = append(kept, id)
+				continue
+			}
+
+			if err := image.Delete(id); err != nil {
+				return 0, err
+			}
+			fmt.Printf("Deleted: %s\n", id)
+		}
+
+		if len(kept) == len(pending) {
+			break
+		}
+		pending = kept
+	}
+
+	if err := cache.dropDeleted(); err != nil {
+		return 0, err
+	}
+
+	return image.PruneLayers(refs)
+}
+
+// dropDeleted removes the entries of the images which are no longer in the
+// store, the cache file is removed when none is left.
+func (cache *buildCache) dropDeleted() error {
+	var images []string
+	exist := map[string]bool{}
+	for _, id := range cache.Images {
+		if img, err := image.Lookup(id); err == nil && img.ID == id {
+			images = append(images, id)
+			exist[id] = true
+		}
+	}
+	for key, id := range cache.Entries {
+		if !exist[id] {
+			delete(cache.Entries, key)
+		}
+	}
+	cache.Images = images
+
+	if len(images) == 0 {
+		if err := os.Remove(cachePath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return cache.dump()
+}
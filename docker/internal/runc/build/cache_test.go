@@ -0,0 +1,61 @@
+package build
+
+import (
+	"docker/internal/runc/image"
+	"docker/internal/utils/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneKeepsEntriesOfKeptImages(t *testing.T) {
+	root := t.TempDir()
+	config.SetRoot(filepath.Join(root, "run"))
+	config.SetDataRoot(filepath.Join(root, "data"))
+	config.SetStorageDriver("vfs")
+
+	contextDir := writeContext(t, map[string]string{
+		"Dockerfile": "FROM scratch\nCOPY a /a\nCOPY b /b\n",
+		"a":          "a",
+		"b":          "b",
+	})
+	built, err := Build(&Options{ContextDir: contextDir, Tags: []string{"app:1"}}, nil)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	// an image of the cache nothing refers to any more
+	if err := os.WriteFile(filepath.Join(contextDir, "b"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dangling, err := Build(&Options{ContextDir: contextDir}, nil)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	if _, err := Prune(); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if _, err := image.Lookup(dangling.ID); err == nil {
+		t.Fatal("the unreferenced image of the cache is kept")
+	}
+
+	cache, err := loadCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Entries) != 2 {
+		t.Fatalf("the cache has %v entries, want the 2 of app:1", len(cache.Entries))
+	}
+
+	if err := os.WriteFile(filepath.Join(contextDir, "b"), []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := Build(&Options{ContextDir: contextDir}, nil)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if rebuilt.ID != built.ID {
+		t.Fatalf("the rebuild produced %v, want the cached %v", rebuilt.ID, built.ID)
+	}
+}
@@ -0,0 +1,333 @@
+package build
+
+import (
+	"docker/internal/runc/image"
+	"docker/internal/runc/storage"
+	"docker/internal/utils/archive"
+	"docker/internal/utils/id"
+	upath "docker/internal/utils/path"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rootfs is a throwaway writable rootfs of the current image, its diff is
+// committed as the new layer.
+type rootfs struct {
+	driver storage.Driver
+	id     string
+	root   string
+}
+
+// mountRootfs creates the rootfs of the image with the storage driver, an
+// image without layers such as scratch starts empty.
+func mountRootfs(img *image.Image) (*rootfs, error) {
+	driver, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	var lowerdirs []string
+	if img != nil {
+		lowerdirs = img.LowerDirs()
+	}
+
+	rfs := &rootfs{driver: driver, id: "build-" + id.GenerateContainerId()}
+	if err := driver.Create(rfs.id, lowerdirs, nil); err != nil {
+		driver.Remove(rfs.id)
+		return nil, fmt.Errorf("minidocker: create build rootfs failed [%v]", err)
+	}
+
+	if rfs.root, err = driver.Mount(rfs.id); err != nil {
+		driver.Remove(rfs.id)
+		return nil, fmt.Errorf("minidocker: mount build rootfs failed [%v]", err)
+	}
+
+	return rfs, nil
+}
+
+func (rfs *rootfs) remove() {
+	rfs.driver.Remove(rfs.id)
+}
+
+// diff streams the changes of the rootfs, the reader must be closed.
+func (rfs *rootfs) diff() io.ReadCloser {
+	return storage.DiffReader(rfs.driver, rfs.id)
+}
+
+// copyFile copies a regular file, a symlink or a directory entry, the
+// content of a directory is copied by the caller.
+func copyFile(src, dst string, info os.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, info.Mode()&os.ModePerm); err != nil {
+			return err
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, info.Mode()&os.ModePerm); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("minidocker: unsupported file type of %v", src)
+	}
+
+	// files of the build context are owned by root in the image
+	if err := os.Lchown(dst, 0, 0); err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+
+	return nil
+}
+
+// scopedPath resolves the parent of file inside root, file itself is
+// replaced rather than followed when it is a symlink.
+func scopedPath(root, file string) (string, error) {
+	dir, err := upath.FollowSymlinkInScope(root, path.Dir(file))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, path.Base(file)), nil
+}
+
+// sourceRoot is where COPY and ADD find their sources, the build context
+// with its .dockerignore or the rootfs of a stage for COPY --from.
+type sourceRoot struct {
+	dir    string
+	ignore *ignoreMatcher
+}
+
+// match resolves the source pattern inside the source root.
+func (root *sourceRoot) match(src string) ([]string, error) {
+	src = path.Clean("/" + src)
+	dir, err := upath.FollowSymlinkInScope(root.dir, path.Dir(src))
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, path.Base(src)))
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []string
+	for _, match := range matches {
+		rel, err := filepath.Rel(root.dir, match)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." || !root.ignore.excluded(rel) {
+			sources = append(sources, match)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("minidocker: no source matches %v", src)
+	}
+
+	return sources, nil
+}
+
+// walk walks a source, the paths excluded by .dockerignore are skipped.
+func (root *sourceRoot) walk(src string, fn func(file, rel string, info os.FileInfo) error) error {
+	return filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		rootRel, _ := filepath.Rel(root.dir, file)
+		if rel != "." && root.ignore.excluded(rootRel) {
+			if info.IsDir() && !root.ignore.hasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		return fn(file, rel, info)
+	})
+}
+
+// copySource copies a file or the content of a directory to dst inside the
+// rootfs.
+func copySource(rfs *rootfs, root *sourceRoot, src, dst string, dstIsDir, extract bool) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		// local archives are extracted into the destination directory
+		if extract && info.Mode().IsRegular() && archive.IsArchive(src) {
+			target, err := upath.FollowSymlinkInScope(rfs.root, dst)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			if err := archive.UntarFile(src, target, nil); err != nil {
+				return fmt.Errorf("minidocker: extract %v failed [%v]", src, err)
+			}
+			return nil
+		}
+
+		if dstIsDir {
+			dst = path.Join(dst, filepath.Base(src))
+		}
+
+		target, err := scopedPath(rfs.root, dst)
+		if err != nil {
+			return err
+		}
+		return copyFile(src, target, info)
+	}
+
+	return root.walk(src, func(file, rel string, info os.FileInfo) error {
+		target, err := upath.FollowSymlinkInScope(rfs.root, dst)
+		if rel != "." {
+			target, err = scopedPath(rfs.root, path.Join(dst, filepath.ToSlash(rel)))
+		}
+		if err != nil {
+			return err
+		}
+
+		return copyFile(file, target, info)
+	})
+}
+
+// copy runs COPY and ADD, the sources are copied into a throwaway rootfs
+// of the current image and its upper directory becomes the new layer.
+func (b *Builder) copy(instruction *Instruction) error {
+	args := instruction.paths()
+	if len(args) < 2 {
+		return fmt.Errorf("minidocker: %v requires at least a source and a destination", instruction.Command)
+	}
+
+	srcs, dst := args[:len(args)-1], b.expand(args[len(args)-1])
+	dstIsDir := strings.HasSuffix(dst, "/") || len(srcs) > 1
+	if !path.IsAbs(dst) {
+		dst = path.Join(b.workdir(), dst)
+	}
+
+	root := &sourceRoot{dir: b.options.ContextDir, ignore: b.ignore}
+	from, hasFrom := instruction.Flags["from"]
+	if hasFrom {
+		if instruction.Command != "COPY" {
+			return fmt.Errorf("minidocker: --from is only supported by COPY")
+		}
+
+		img, err := b.stageImage(from)
+		if err != nil {
+			return err
+		}
+
+		srcfs, err := mountRootfs(img)
+		if err != nil {
+			return err
+		}
+		defer srcfs.remove()
+		root = &sourceRoot{dir: srcfs.root, ignore: &ignoreMatcher{}}
+	}
+
+	var sources []string
+	for _, src := range srcs {
+		if strings.Contains(src, "://") {
+			return fmt.Errorf("minidocker: remote sources are not supported by %v", instruction.Command)
+		}
+
+		matches, err := root.match(b.expand(src))
+		if err != nil {
+			return err
+		}
+		sources = append(sources, matches...)
+	}
+	if len(sources) > 1 {
+		dstIsDir = true
+	}
+
+	contentHash, err := hashSources(root, sources)
+	if err != nil {
+		return err
+	}
+	if b.probeCache(instruction, contentHash) {
+		return nil
+	}
+
+	rfs, err := mountRootfs(b.image)
+	if err != nil {
+		return err
+	}
+	defer rfs.remove()
+
+	// a single file is copied into an existing directory, as docker does
+	if !dstIsDir {
+		target, err := upath.FollowSymlinkInScope(rfs.root, dst)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			dstIsDir = true
+		}
+	}
+
+	for _, src := range sources {
+		if err := copySource(rfs, root, src, dst, dstIsDir, instruction.Command == "ADD"); err != nil {
+			return err
+		}
+	}
+
+	diff := rfs.diff()
+	defer diff.Close()
+
+	return b.commit(diff, instruction)
+}
@@ -0,0 +1,66 @@
+package build
+
+import (
+	"docker/internal/runc/image"
+	"docker/internal/utils/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeContext(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestCopyFileIntoExistingDirectory(t *testing.T) {
+	root := t.TempDir()
+	config.SetRoot(filepath.Join(root, "run"))
+	config.SetDataRoot(filepath.Join(root, "data"))
+	config.SetStorageDriver("vfs")
+
+	contextDir := writeContext(t, map[string]string{
+		"Dockerfile": "FROM scratch\nCOPY etc /etc\nCOPY app.conf /etc\nCOPY app.conf /etc/renamed.conf\n",
+		"etc/passwd": "root:x:0:0:root:/root:/bin/sh\n",
+		"app.conf":   "debug = true\n",
+	})
+
+	img, err := Build(&Options{ContextDir: contextDir, NoCache: true}, nil)
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if len(img.RootFS.DiffIDs) != 3 {
+		t.Fatalf("the image has %v layers, want 3", len(img.RootFS.DiffIDs))
+	}
+
+	// the layer of the second COPY keeps /etc a directory
+	layer := image.LayerDiffPath(img.RootFS.DiffIDs[1])
+	if info, err := os.Lstat(filepath.Join(layer, "etc")); err != nil || !info.IsDir() {
+		t.Fatalf("/etc is not a directory in the layer: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(layer, "etc", "app.conf"))
+	if err != nil || string(content) != "debug = true\n" {
+		t.Fatalf("/etc/app.conf = %q, %v", content, err)
+	}
+	if _, err := os.Lstat(filepath.Join(layer, "etc", "passwd")); err == nil {
+		t.Fatal("the layer of the second COPY holds the content of the first")
+	}
+
+	// a destination which does not exist is the name of the file
+	layer = image.LayerDiffPath(img.RootFS.DiffIDs[2])
+	if info, err := os.Lstat(filepath.Join(layer, "etc", "renamed.conf")); err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("/etc/renamed.conf is not a file: %v", err)
+	}
+}
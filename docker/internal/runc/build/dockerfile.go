@@ -0,0 +1,131 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instruction is a parsed line of the Dockerfile such as
+// `COPY --from=builder /app /app`.
+type Instruction struct {
+	Command  string
+	Flags    map[string]string
+	Args     string
+	Original string
+	Line     int
+}
+
+// Parse reads the instructions of a Dockerfile, lines ending with a
+// backslash are continued and lines starting with # are comments.
+func Parse(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	var buffer strings.Builder
+	start := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if buffer.Len() == 0 {
+			if text == "" {
+				continue
+			}
+			start = line
+		}
+
+		if strings.HasSuffix(text, "\\") {
+			buffer.WriteString(strings.TrimSuffix(text, "\\"))
+			buffer.WriteString(" ")
+			continue
+		}
+		buffer.WriteString(text)
+
+		instruction, err := parseInstruction(buffer.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		buffer.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if buffer.Len() != 0 {
+		instruction, err := parseInstruction(buffer.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("minidocker: the Dockerfile has no instructions")
+	}
+
+	return instructions, nil
+}
+
+func parseInstruction(text string, line int) (*Instruction, error) {
+	fields := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	instruction := &Instruction{
+		Command:  strings.ToUpper(fields[0]),
+		Flags:    map[string]string{},
+		Original: strings.TrimSpace(text),
+		Line:     line,
+	}
+	if len(fields) == 2 {
+		instruction.Args = strings.TrimSpace(fields[1])
+	}
+
+	// flags such as --from=stage precede the arguments
+	for strings.HasPrefix(instruction.Args, "--") {
+		fields := strings.SplitN(instruction.Args, " ", 2)
+		key, value, _ := strings.Cut(strings.TrimPrefix(fields[0], "--"), "=")
+		instruction.Flags[key] = value
+		instruction.Args = ""
+		if len(fields) == 2 {
+			instruction.Args = strings.TrimSpace(fields[1])
+		}
+	}
+
+	if instruction.Args == "" {
+		return nil, fmt.Errorf("minidocker: line %v: %v requires arguments", line, instruction.Command)
+	}
+
+	return instruction, nil
+}
+
+// jsonArgs returns the arguments of the exec form ["a", "b"].
+func (instruction *Instruction) jsonArgs() ([]string, bool) {
+	var args []string
+	if !strings.HasPrefix(instruction.Args, "[") || json.Unmarshal([]byte(instruction.Args), &args) != nil {
+		return nil, false
+	}
+
+	return args, true
+}
+
+// command returns the exec form as is and the shell form run by /bin/sh -c.
+func (instruction *Instruction) command() []string {
+	if args, ok := instruction.jsonArgs(); ok {
+		return args
+	}
+
+	return []string{"/bin/sh", "-c", instruction.Args}
+}
+
+// paths returns the arguments of COPY and ADD in both forms.
+func (instruction *Instruction) paths() []string {
+	if args, ok := instruction.jsonArgs(); ok {
+		return args
+	}
+
+	return strings.Fields(instruction.Args)
+}
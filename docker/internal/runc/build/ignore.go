@@ -0,0 +1,131 @@
+package build
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type ignorePattern struct {
+	regexp  *regexp.Regexp
+	exclude bool
+}
+
+// ignoreMatcher matches the paths of the build context against
+// .dockerignore, the last matching pattern wins and ! re-includes a path.
+type ignoreMatcher struct {
+	patterns []*ignorePattern
+}
+
+// compilePattern turns a .dockerignore pattern into a regexp, ** matches
+// any number of directories and * or ? never match a separator.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					expr.WriteString("(.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				expr.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			expr.WriteString(strings.Replace(pattern[i:i+end+1], "[!", "[^", 1))
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// a matched directory excludes everything below it
+	expr.WriteString("(/.*)?$")
+
+	return regexp.Compile(expr.String())
+}
+
+func loadIgnore(contextDir string) (*ignoreMatcher, error) {
+	matcher := &ignoreMatcher{}
+
+	file, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return matcher, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		exclude := true
+		if strings.HasPrefix(line, "!") {
+			exclude, line = false, strings.TrimSpace(line[1:])
+		}
+
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+line)), "/")
+		if line == "" {
+			continue
+		}
+
+		re, err := compilePattern(line)
+		if err != nil {
+			return nil, err
+		}
+		matcher.patterns = append(matcher.patterns, &ignorePattern{regexp: re, exclude: exclude})
+	}
+
+	return matcher, scanner.Err()
+}
+
+// excluded tells whether the path relative to the context root is ignored.
+func (matcher *ignoreMatcher) excluded(rel string) bool {
+	rel = filepath.ToSlash(rel)
+
+	excluded := false
+	for _, pattern := range matcher.patterns {
+		if pattern.regexp.MatchString(rel) {
+			excluded = pattern.exclude
+		}
+	}
+
+	return excluded
+}
+
+// hasExceptions tells whether a ! pattern may re-include a path below an
+// excluded directory, in which case the directory is still walked.
+func (matcher *ignoreMatcher) hasExceptions() bool {
+	for _, pattern := range matcher.patterns {
+		if !pattern.exclude {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"docker/internal/runc/build"
+	"docker/internal/runc/cgroups/subsystem"
+	"docker/internal/runc/container"
+	"docker/internal/runc/image"
+	"docker/internal/runc/storage"
+	"docker/internal/utils/id"
+	"fmt"
+	"io"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// buildExecutor runs the RUN instructions of build in attached containers.
+type buildExecutor struct{}
+
+func (executor *buildExecutor) Run(imageID string, command []string, commit func(diff io.Reader) error) error {
+	config := &containerConfig{
+		name:               "build-" + id.GenerateContainerId(),
+		image:              imageID,
+		attach:             true,
+		commands:           command,
+		overrideEntrypoint: true,
+		resourceConfig:     subsystem.NewResourceConfig("", "", ""),
+	}
+
+	defer func() {
+		if err := container.RunContainerRemove(config.name); err != nil {
+			log.Errorf("remove build container %v failed: %v", config.name, err)
+		}
+	}()
+
+	fmt.Printf(" ---> Running in %s\n", config.name)
+	if err := config.runAttached(); err != nil {
+		return err
+	}
+
+	diff := storage.DiffReader(config.driver, config.name)
+	defer diff.Close()
+
+	return commit(diff)
+}
+
+// runAttached runs the container in the foreground and waits for it, the
+// container is kept so its diff can be committed.
+func (config *containerConfig) runAttached() error {
+	if err := config.startupParentProcess(); err != nil {
+		return err
+	}
+
+	c, err := config.recordContainerInfo()
+	if err != nil {
+		return err
+	}
+
+	config.setContainerCgroup()
+	config.sendInitCommand()
+
+	err = config.parent.Wait()
+	c.UpdateContainerInfo(container.EXIT)
+	if err != nil {
+		return fmt.Errorf("minidocker: the command %v returned an error [%v]", strings.Join(config.commands, " "), err)
+	}
+
+	return nil
+}
+
+var BuildCommand = cli.Command{
+	Name: "build",
+	Usage: `build an image from a Dockerfile
+			minidocker build -t [name:tag] -f [Dockerfile] [context]`,
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "t, tag",
+			Usage: "name and optionally a tag in the name:tag format",
+		},
+		cli.StringFlag{
+			Name:  "f, file",
+			Usage: "name of the Dockerfile, default is context/Dockerfile",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use the build cache",
+		},
+		cli.StringFlag{
+			Name:  "target",
+			Usage: "name of the stage to stop at",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return fmt.Errorf("minidocker: build requires exactly one context directory")
+		}
+
+		options := &build.Options{
+			ContextDir: context.Args().First(),
+			Dockerfile: context.String("file"),
+			Tags:       context.StringSlice("tag"),
+			NoCache:    context.Bool("no-cache"),
+			Target:     context.String("target"),
+		}
+
+		_, err := build.Build(options, &buildExecutor{})
+		return err
+	},
+}
+
+var BuilderCommand = cli.Command{
+	Name:  "builder",
+	Usage: "manage the build cache",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove the build cache and the layers only it refers to",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) != 0 {
+					return fmt.Errorf("minidocker: no args needed for builder prune")
+				}
+
+				reclaimed, err := build.Prune()
+				if err != nil {
+					return fmt.Errorf("minidocker: prune build cache failed [%v]", err)
+				}
+
+				fmt.Printf("Total reclaimed space: %s\n", image.HumanSize(reclaimed))
+				return nil
+			},
+		},
+	},
+}
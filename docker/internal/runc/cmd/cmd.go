@@ -3,7 +3,9 @@ package cmd
 import (
 	"docker/internal/runc/cgroups/subsystem"
 	"docker/internal/runc/container"
+	"docker/internal/runc/image"
 	"docker/internal/runc/network"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -17,7 +19,7 @@ import (
 
 func parseCmdsFrom(context *cli.Context) []string {
 	var cmds []string
-	for _, arg := range context.Args() {
+	for _, arg := range context.Args().Tail() {
 		cmds = append(cmds, arg)
 	}
 
@@ -36,21 +38,30 @@ func ttyEnable(context *cli.Context) bool {
 
 func parseContainerConfig(context *cli.Context) *containerConfig {
 	return &containerConfig{
-		name:           context.String("name"),
-		volume:         context.String("v"),
-		network:        context.String("net"),
-		portmapping:    context.String("p"),
-		tty:            context.Bool("it"),
-		commands:       parseCmdsFrom(context),
-		envs:           context.StringSlice("e"),
-		resourceConfig: subsystem.NewResourceConfig(context.String("m"), context.String("cpuset"), context.String("cpushare")),
+		name:               context.String("name"),
+		image:              context.Args().First(),
+		binds:              context.StringSlice("v"),
+		mountSpecs:         context.StringSlice("mount"),
+		tmpfs:              context.StringSlice("tmpfs"),
+		volumesFrom:        context.StringSlice("volumes-from"),
+		network:            context.String("net"),
+		portmapping:        context.String("p"),
+		tty:                context.Bool("it"),
+		commands:           parseCmdsFrom(context),
+		envs:               context.StringSlice("e"),
+		entrypoint:         context.String("entrypoint"),
+		overrideEntrypoint: context.IsSet("entrypoint"),
+		workdir:            context.String("workdir"),
+		storageOpts:        context.StringSlice("storage-opt"),
+		resourceConfig:     subsystem.NewResourceConfig(context.String("m"), context.String("cpuset"), context.String("cpushare")),
 	}
 }
 
 var RunCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroups limit
-			minidocker run -it [command]`,
+			minidocker run -it [image] [command]
+			the image entrypoint and cmd are used without command`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "it",
@@ -72,9 +83,21 @@ var RunCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "volume",
+			Usage: "bind mount a host path or a named volume, such as -v name:/path[:ro,nocopy,rslave], repeatable",
+		},
+		cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "attach a mount, such as --mount type=tmpfs,dst=/run,tmpfs-size=64m, types are bind, volume and tmpfs",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs, such as --tmpfs /run:size=64m,mode=1777",
+		},
+		cli.StringSliceFlag{
+			Name:  "volumes-from",
+			Usage: "mount the volumes of a container, such as --volumes-from app[:ro]",
 		},
 		cli.StringFlag{
 			Name:  "name",
@@ -92,10 +115,22 @@ var RunCommand = cli.Command{
 			Name:  "p",
 			Usage: "container port mapping",
 		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "overwrite the image entrypoint",
+		},
+		cli.StringFlag{
+			Name:  "w, workdir",
+			Usage: "working directory inside the container",
+		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "storage driver option, such as size=2G to limit the writable layer",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
-			return fmt.Errorf("minidocker: failed to get container command [%v]", context.Args())
+			return fmt.Errorf("minidocker: failed to get container image [%v]", context.Args())
 		}
 
 		if ttyEnable(context) {
@@ -103,7 +138,7 @@ var RunCommand = cli.Command{
 		}
 
 		if err := parseContainerConfig(context).runContainer(); err != nil {
-			return fmt.Errorf("minidocker: failed to run container")
+			return fmt.Errorf("minidocker: failed to run container [%v]", err)
 		}
 
 		return nil
@@ -111,16 +146,35 @@ var RunCommand = cli.Command{
 }
 
 var CommitCommand = cli.Command{
-	Name:  "commit",
-	Usage: "commit container to image with tar",
+	Name: "commit",
+	Usage: `commit the container changes into a new image
+			minidocker commit [container] [name:tag]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "a, author",
+			Usage: "image author",
+		},
+		cli.StringFlag{
+			Name:  "m, message",
+			Usage: "commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "c, change",
+			Usage: "apply instruction to the image config, such as CMD or ENV",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		if len(context.Args()) != 1 {
+		if len(context.Args()) < 1 || len(context.Args()) > 2 {
 			return errors.New("minidocker: wrong args for commit")
 		}
 
-		log.Infof("minidocker: commit container into tar image")
-		imageName := context.Args().Get(0)
-		return container.RunContainerCommit(imageName)
+		log.Infof("minidocker: commit container into image")
+		options := &image.CommitOptions{
+			Author:  context.String("author"),
+			Message: context.String("message"),
+			Changes: context.StringSlice("change"),
+		}
+		return container.RunContainerCommit(context.Args().Get(0), context.Args().Get(1), options)
 	},
 }
 
@@ -177,6 +231,52 @@ var LogCommand = cli.Command{
 	},
 }
 
+var InspectCommand = cli.Command{
+	Name: "inspect",
+	Usage: `show the container info and the usage of its writable layer
+			minidocker inspect [container]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return errors.New("minidocker: inspect requires exactly one container")
+		}
+
+		return container.RunContainerInspect(context.Args().First())
+	},
+}
+
+var DiffCommand = cli.Command{
+	Name: "diff",
+	Usage: `list the files the container added (A), changed (C) or deleted (D)
+			minidocker diff [container]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return errors.New("minidocker: diff requires exactly one container")
+		}
+
+		return container.RunContainerDiff(context.Args().First(), context.String("format"))
+	},
+}
+
+var CopyCommand = cli.Command{
+	Name: "cp",
+	Usage: `copy files between a container and the host
+			minidocker cp [container:]src [container:]dst
+			a source ending with /. copies the content of the directory`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 2 {
+			return errors.New("minidocker: cp requires a source and a destination")
+		}
+
+		return container.RunContainerCopy(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
 var ListCommand = cli.Command{
 	Name:  "ps",
 	Usage: "list container",
@@ -283,9 +383,15 @@ var InitCommand = cli.Command{
 }
 
 func (config *containerConfig) sendInitCommand() {
-	command := strings.Join(config.commands, " ")
-	log.Info("minidocker: send command: ", command)
+	log.Info("minidocker: send command: ", strings.Join(config.commands, " "))
+
+	initConfig, _ := json.Marshal(&container.InitConfig{
+		Args:       config.commands,
+		WorkingDir: config.workdir,
+		User:       config.user,
+		Mounts:     config.mounts,
+	})
 
-	config.writePipe.WriteString(command)
+	config.writePipe.Write(initConfig)
 	config.writePipe.Close()
 }
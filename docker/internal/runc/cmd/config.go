@@ -4,8 +4,12 @@ import (
 	"docker/internal/runc/cgroups"
 	"docker/internal/runc/cgroups/subsystem"
 	"docker/internal/runc/container"
+	"docker/internal/runc/image"
 	"docker/internal/runc/network"
+	"docker/internal/runc/storage"
+	"docker/internal/runc/volume"
 	"docker/internal/utils/id"
+	"fmt"
 	"os"
 	"os/exec"
 	"strconv"
@@ -15,72 +19,167 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
 type containerConfig struct {
-	name           string
-	volume         string
-	network        string
-	portmapping    string
-	tty            bool
-	commands       []string
-	envs           []string
-	resourceConfig *subsystem.ResourceConfig
-	parent         *exec.Cmd
-	writePipe      *os.File
+	name               string
+	image              string
+	imageID            string
+	layers             []string
+	driver             storage.Driver
+	binds              []string
+	mountSpecs         []string
+	tmpfs              []string
+	volumesFrom        []string
+	mounts             []container.Mount
+	volumes            []string
+	network            string
+	portmapping        string
+	tty                bool
+	attach             bool
+	commands           []string
+	envs               []string
+	entrypoint         string
+	overrideEntrypoint bool
+	workdir            string
+	storageOpts        []string
+	user               string
+	resourceConfig     *subsystem.ResourceConfig
+	parent             *exec.Cmd
+	writePipe          *os.File
 }
 
+// exitContainer cleans up after a foreground container, its writable layer
+// is kept until remove so the stopped container can still be exported,
+// committed, diffed and copied from. Its plugin volumes are unmounted.
 func (config *containerConfig) exitContainer() error {
 	if !config.tty {
 		return nil
 	}
 
-	dirs := strings.Split(config.volume, ":")
-	if len(dirs) == 2 {
-		volumeContainerDir := dirs[1]
-		volumeContainerMountPoint := container.Mergedir + volumeContainerDir
-		if err := syscall.Unmount(volumeContainerMountPoint, 0); err != nil {
-			log.Error(err)
-			return err
+	if config.parent == nil {
+		return nil
+	}
+
+	for _, name := range config.volumes {
+		if err := volume.Unmount(name, config.name); err != nil {
+			log.Errorf("unmount volume %v failed: %v", name, err)
 		}
 	}
 
-	if err := syscall.Unmount(container.Mergedir, 0); err != nil {
+	if err := syscall.Unmount("/proc", 0); err != nil {
 		log.Error(err)
 		return err
 	}
 
-	if err := os.RemoveAll(container.Mergedir); err != nil {
-		log.Errorf("remove merge layer dir %v failed: %v", container.Mergedir, err)
+	return nil
+}
+
+func (config *containerConfig) setContainerName() {
+	if config.name == "" {
+		config.name = id.GenerateContainerId()
+	}
+}
+
+// mergeImageConfig merges the image config into the container config, the
+// command line takes precedence over the image.
+func (config *containerConfig) mergeImageConfig(img *image.Image) error {
+	entrypoint, cmd := img.Config.Entrypoint, img.Config.Cmd
+	if config.overrideEntrypoint {
+		entrypoint, cmd = nil, nil
+		if config.entrypoint != "" {
+			entrypoint = []string{config.entrypoint}
+		}
 	}
 
-	if err := os.RemoveAll(container.Workdir); err != nil {
-		log.Errorf("remove work layer dir %v failed: %v", container.Upperdir, err)
+	if len(config.commands) != 0 {
+		cmd = config.commands
 	}
 
-	if err := os.RemoveAll(container.Upperdir); err != nil {
-		log.Errorf("remove upper layer dir %v failed: %v", container.Upperdir, err)
+	config.commands = append(append([]string{}, entrypoint...), cmd...)
+	if len(config.commands) == 0 {
+		return fmt.Errorf("minidocker: no command specified for image %v", config.image)
 	}
 
-	if err := syscall.Unmount("/proc", 0); err != nil {
-		log.Error(err)
-		return err
+	envs := append([]string{}, img.Config.Env...)
+	hasPath := false
+	for _, env := range envs {
+		if strings.HasPrefix(env, "PATH=") {
+			hasPath = true
+		}
+	}
+	if !hasPath {
+		envs = append(envs, "PATH="+defaultPath)
+	}
+
+	for _, env := range config.envs {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok {
+			// -e KEY takes the value from the current environment
+			if value, ok = os.LookupEnv(key); !ok {
+				continue
+			}
+		}
+		envs = image.SetEnv(envs, key, value)
+	}
+	config.envs = envs
+
+	if config.workdir == "" {
+		config.workdir = img.Config.WorkingDir
 	}
+	config.user = img.Config.User
 
 	return nil
 }
 
-func (config *containerConfig) setContainerName() {
-	if config.name == "" {
-		config.name = id.GenerateContainerId()
+// releaseVolumes drops the container from the users of its volumes when it
+// fails to start.
+func (config *containerConfig) releaseVolumes() {
+	for _, name := range config.volumes {
+		if err := volume.Release(name, config.name); err != nil {
+			log.Errorf("release volume %v failed: %v", name, err)
+		}
 	}
 }
 
 func (config *containerConfig) startupParentProcess() error {
-	parent, writePipe, err := container.NewParentProcess(config.tty, config.volume, config.name, config.envs)
+	img, err := image.Lookup(config.image)
+	if err != nil {
+		return err
+	}
+	config.imageID, config.layers = img.ID, img.RootFS.DiffIDs
+
+	if err := config.mergeImageConfig(img); err != nil {
+		return err
+	}
+
+	storageOptions, err := storage.ParseOptions(config.storageOpts)
 	if err != nil {
 		return err
 	}
 
+	if config.driver, err = storage.Default(); err != nil {
+		return err
+	}
+
+	if err := config.prepareMounts(); err != nil {
+		return err
+	}
+
+	parent, writePipe, err := container.NewParentProcess(config.tty, config.name, config.driver, storageOptions, img.LowerDirs(), config.envs)
+	if err != nil {
+		config.releaseVolumes()
+		return err
+	}
+
+	// an attached container shares the output of minidocker without a tty
+	if config.attach {
+		parent.Stdin, parent.Stdout, parent.Stderr = nil, os.Stdout, os.Stderr
+	}
+
 	if err := parent.Start(); err != nil {
+		config.driver.Remove(config.name)
+		config.releaseVolumes()
 		return err
 	}
 
@@ -89,7 +188,9 @@ func (config *containerConfig) startupParentProcess() error {
 }
 
 func (config *containerConfig) recordContainerInfo() (*container.Container, error) {
-	c := container.New(config.name, strconv.Itoa(config.parent.Process.Pid), strings.Join(config.commands, " "), container.RUNNING)
+	c := container.New(config.name, strconv.Itoa(config.parent.Process.Pid), config.image, strings.Join(config.commands, " "), container.RUNNING)
+	c.ImageID, c.Layers, c.Driver = config.imageID, config.layers, config.driver.Name()
+	c.Volumes, c.Mounts = config.volumes, config.mounts
 	if err := c.RecordContainerInfo(); err != nil {
 		return nil, err
 	}
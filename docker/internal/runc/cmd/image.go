@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"docker/internal/runc/container"
+	"docker/internal/runc/image"
+	"docker/internal/runc/registry"
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+var ImagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a, all",
+			Usage: "show intermediate images",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 0 {
+			return fmt.Errorf("minidocker: no args needed for images")
+		}
+
+		return image.RunImageList(context.Bool("all"))
+	},
+}
+
+var ImportCommand = cli.Command{
+	Name: "import",
+	Usage: `import a rootfs tarball as image, - reads stdin
+			minidocker import [tar] [name:tag]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 2 {
+			return fmt.Errorf("minidocker: wrong args %v for import", context.Args())
+		}
+
+		return image.RunImageImport(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var ExportCommand = cli.Command{
+	Name: "export",
+	Usage: `export the rootfs of a container as a tarball
+			minidocker export -o [tar] [container]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o, output",
+			Usage: "write to a file instead of stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return fmt.Errorf("minidocker: export requires exactly one container")
+		}
+
+		return container.RunContainerExport(context.Args().First(), context.String("output"))
+	},
+}
+
+var LoadCommand = cli.Command{
+	Name: "load",
+	Usage: `load images from a docker save archive or an OCI image layout
+			minidocker load -i [tar]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i, input",
+			Usage: "read from tar archive file instead of stdin",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 0 {
+			return fmt.Errorf("minidocker: wrong args %v for load", context.Args())
+		}
+
+		return image.RunImageLoad(context.String("input"))
+	},
+}
+
+var SaveCommand = cli.Command{
+	Name: "save",
+	Usage: `save images into a tarball readable as docker archive and OCI image layout
+			minidocker save -o [tar] [image...]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o, output",
+			Usage: "write to a file instead of stdout",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) == 0 {
+			return fmt.Errorf("minidocker: at least one image is needed for save")
+		}
+
+		return image.RunImageSave(context.String("output"), context.Args())
+	},
+}
+
+var PullCommand = cli.Command{
+	Name: "pull",
+	Usage: `pull an image from a registry
+			minidocker pull [name:tag]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return fmt.Errorf("minidocker: wrong args %v for pull", context.Args())
+		}
+
+		_, err := registry.Pull(context.Args().Get(0))
+		return err
+	},
+}
+
+var PushCommand = cli.Command{
+	Name: "push",
+	Usage: `push an image to a registry
+			minidocker push [registry/name:tag]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return fmt.Errorf("minidocker: wrong args %v for push", context.Args())
+		}
+
+		return registry.Push(context.Args().Get(0))
+	},
+}
+
+var LoginCommand = cli.Command{
+	Name: "login",
+	Usage: `store the credentials of a registry in the config file
+			minidocker login -u [username] -p [password] [registry]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "u, username",
+			Usage: "registry username",
+		},
+		cli.StringFlag{
+			Name:  "p, password",
+			Usage: "registry password",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) > 1 {
+			return fmt.Errorf("minidocker: wrong args %v for login", context.Args())
+		}
+
+		host := context.Args().First()
+		if host == "" {
+			host = "docker.io"
+		}
+
+		if err := registry.Login(host, context.String("username"), context.String("password")); err != nil {
+			return err
+		}
+
+		fmt.Println("Login Succeeded")
+		return nil
+	},
+}
+
+var RemoveImageCommand = cli.Command{
+	Name: "rmi",
+	Usage: `remove images, an image used by a container requires -f
+			minidocker rmi [image...]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f, force",
+			Usage: "remove the image even if a container uses it",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) == 0 {
+			return fmt.Errorf("minidocker: rmi requires at least one image")
+		}
+
+		refs, err := container.ImageReferences()
+		if err != nil {
+			return err
+		}
+
+		return image.RunImageRemove(context.Args(), context.Bool("force"), refs)
+	},
+}
+
+var TagCommand = cli.Command{
+	Name: "tag",
+	Usage: `create a tag which refers to the image
+			minidocker tag [image] [name:tag]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 2 {
+			return fmt.Errorf("minidocker: wrong args %v for tag", context.Args())
+		}
+
+		img, err := image.Lookup(context.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		return image.Tag(img, context.Args().Get(1))
+	},
+}
+
+var HistoryCommand = cli.Command{
+	Name: "history",
+	Usage: `show the history of an image
+			minidocker history [image]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate the output",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) != 1 {
+			return fmt.Errorf("minidocker: wrong args %v for history", context.Args())
+		}
+
+		return image.RunImageHistory(context.Args().First(), context.Bool("no-trunc"))
+	},
+}
+
+var ImageCommand = cli.Command{
+	Name:  "image",
+	Usage: "manage images",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove dangling images and the layers no image or container refers to",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) != 0 {
+					return fmt.Errorf("minidocker: no args needed for image prune")
+				}
+
+				refs, err := container.ImageReferences()
+				if err != nil {
+					return err
+				}
+
+				return image.RunImagePrune(refs)
+			},
+		},
+	},
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"docker/internal/runc/container"
+	"docker/internal/runc/storage"
+	"docker/internal/runc/volume"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseVolumeSpec parses -v SRC:DST[:OPTS], OPTS is a comma separated list of
+// ro, rw, nocopy and a propagation. A source which is a valid name is a named volume,
+// otherwise a host path, which is created when missing.
+func parseVolumeSpec(spec string) (container.Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return container.Mount{}, fmt.Errorf("minidocker: invalid volume spec %v, SRC:DST[:OPTS] is expected", spec)
+	}
+
+	m := container.Mount{Source: parts[0], Destination: path.Clean(parts[1])}
+	if !path.IsAbs(m.Destination) {
+		return m, fmt.Errorf("minidocker: the destination %v of %v is not absolute", parts[1], spec)
+	}
+
+	if len(parts) == 3 {
+		mode := ""
+		for _, option := range strings.Split(parts[2], ",") {
+			switch {
+			case option == "ro" || option == "rw":
+				if mode != "" {
+					return m, fmt.Errorf("minidocker: duplicate mode %v in %v", option, spec)
+				}
+				mode, m.ReadOnly = option, option == "ro"
+			case container.IsPropagation(option):
+				if m.Propagation != "" {
+					return m, fmt.Errorf("minidocker: duplicate propagation %v in %v", option, spec)
+				}
+				m.Propagation = option
+			case option == "nocopy":
+				m.NoCopy = true
+			default:
+				return m, fmt.Errorf("minidocker: invalid option %v in %v", option, spec)
+			}
+		}
+	}
+
+	if volume.IsName(m.Source) {
+		m.Type, m.Name = container.MountTypeVolume, m.Source
+		return m, nil
+	}
+
+	if m.NoCopy {
+		return m, fmt.Errorf("minidocker: nocopy is only for named volumes in %v", spec)
+	}
+
+	source, err := filepath.Abs(m.Source)
+	if err != nil {
+		return m, err
+	}
+	if err := os.MkdirAll(source, 0755); err != nil && !os.IsExist(err) {
+		return m, fmt.Errorf("minidocker: create the source %v failed [%v]", source, err)
+	}
+	m.Type, m.Source = container.MountTypeBind, source
+
+	return m, nil
+}
+
+// parseMountSpec parses --mount type=TYPE,src=SRC,dst=DST[,OPTION...], the
+// type defaults to volume and a volume without source is anonymous.
+func parseMountSpec(spec string) (container.Mount, error) {
+	m := container.Mount{Type: container.MountTypeVolume}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, hasValue := strings.Cut(field, "=")
+		flag := func() (bool, error) {
+			if !hasValue {
+				return true, nil
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("minidocker: invalid value %v of %v in %v", value, key, spec)
+			}
+			return b, nil
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "type":
+			m.Type = value
+		case "src", "source":
+			m.Source = value
+		case "dst", "destination", "target":
+			m.Destination = value
+		case "ro", "readonly":
+			m.ReadOnly, err = flag()
+		case "bind-propagation":
+			if !container.IsPropagation(value) {
+				return m, fmt.Errorf("minidocker: invalid propagation %v in %v", value, spec)
+			}
+			m.Propagation = value
+		case "volume-nocopy":
+			m.NoCopy, err = flag()
+		case "tmpfs-size":
+			m.TmpfsSize, err = storage.ParseSize(value)
+		case "tmpfs-mode":
+			var mode uint64
+			mode, err = strconv.ParseUint(value, 8, 32)
+			m.TmpfsMode = os.FileMode(mode)
+		default:
+			return m, fmt.Errorf("minidocker: unknown mount option %v in %v", key, spec)
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+
+	if m.Destination == "" || !path.IsAbs(m.Destination) {
+		return m, fmt.Errorf("minidocker: the mount %v needs an absolute destination", spec)
+	}
+	m.Destination = path.Clean(m.Destination)
+
+	switch m.Type {
+	case container.MountTypeBind:
+		if m.Source == "" {
+			return m, fmt.Errorf("minidocker: the bind mount %v needs a source", spec)
+		}
+		source, err := filepath.Abs(m.Source)
+		if err != nil {
+			return m, err
+		}
+		if _, err := os.Stat(source); err != nil {
+			return m, fmt.Errorf("minidocker: the bind source %v does not exist", source)
+		}
+		m.Source = source
+	case container.MountTypeVolume:
+		if m.Source != "" && !volume.IsName(m.Source) {
+			return m, fmt.Errorf("minidocker: invalid volume name %v", m.Source)
+		}
+		m.Name = m.Source
+	case container.MountTypeTmpfs:
+		if m.Source != "" {
+			return m, fmt.Errorf("minidocker: the tmpfs mount %v takes no source", spec)
+		}
+	default:
+		return m, fmt.Errorf("minidocker: unknown mount type %v", m.Type)
+	}
+
+	if m.Type != container.MountTypeBind && m.Propagation != "" {
+		return m, fmt.Errorf("minidocker: bind-propagation is only for bind mounts")
+	}
+	if m.Type != container.MountTypeVolume && m.NoCopy {
+		return m, fmt.Errorf("minidocker: volume-nocopy is only for volume mounts")
+	}
+	if m.Type != container.MountTypeTmpfs && (m.TmpfsSize != 0 || m.TmpfsMode != 0) {
+		return m, fmt.Errorf("minidocker: tmpfs options are only for tmpfs mounts")
+	}
+
+	return m, nil
+}
+
+// parseTmpfsSpec parses --tmpfs DST[:OPTS], OPTS is a comma separated list of
+// size, mode, ro and rw.
+func parseTmpfsSpec(spec string) (container.Mount, error) {
+	dst, opts, _ := strings.Cut(spec, ":")
+	m := container.Mount{Type: container.MountTypeTmpfs, Destination: path.Clean(dst)}
+	if !path.IsAbs(dst) {
+		return m, fmt.Errorf("minidocker: the tmpfs destination %v is not absolute", dst)
+	}
+
+	if opts == "" {
+		return m, nil
+	}
+
+	for _, opt := range strings.Split(opts, ",") {
+		key, value, _ := strings.Cut(opt, "=")
+		var err error
+		switch key {
+		case "ro", "rw":
+			m.ReadOnly = key == "ro"
+		case "size":
+			m.TmpfsSize, err = storage.ParseSize(value)
+		case "mode":
+			var mode uint64
+			mode, err = strconv.ParseUint(value, 8, 32)
+			m.TmpfsMode = os.FileMode(mode)
+		default:
+			return m, fmt.Errorf("minidocker: unknown tmpfs option %v in %v", opt, spec)
+		}
+		if err != nil {
+			return m, fmt.Errorf("minidocker: invalid tmpfs option %v in %v", opt, spec)
+		}
+	}
+
+	return m, nil
+}
+
+// parseMounts parses -v, --mount and --tmpfs, a destination is mounted once.
+// The mounts of --volumes-from are added unless the destination is taken.
+func (config *containerConfig) parseMounts() ([]container.Mount, error) {
+	var mounts []container.Mount
+	add := func(specs []string, parse func(string) (container.Mount, error)) error {
+		for _, spec := range specs {
+			m, err := parse(spec)
+			if err != nil {
+				return err
+			}
+
+			for _, other := range mounts {
+				if other.Destination == m.Destination {
+					return fmt.Errorf("minidocker: duplicate mount point %v", m.Destination)
+				}
+			}
+			mounts = append(mounts, m)
+		}
+
+		return nil
+	}
+
+	if err := add(config.binds, parseVolumeSpec); err != nil {
+		return nil, err
+	}
+	if err := add(config.mountSpecs, parseMountSpec); err != nil {
+		return nil, err
+	}
+	if err := add(config.tmpfs, parseTmpfsSpec); err != nil {
+		return nil, err
+	}
+
+	taken := map[string]bool{}
+	for _, m := range mounts {
+		taken[m.Destination] = true
+	}
+	for _, spec := range config.volumesFrom {
+		shared, err := container.VolumesFrom(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range shared {
+			if !taken[m.Destination] {
+				taken[m.Destination] = true
+				mounts = append(mounts, m)
+			}
+		}
+	}
+
+	return mounts, nil
+}
+
+// prepareMounts parses the mounts of the container, the named volumes record
+// the container as their user and are mounted from their mountpoint.
+func (config *containerConfig) prepareMounts() error {
+	mounts, err := config.parseMounts()
+	if err != nil {
+		return err
+	}
+
+	for i, m := range mounts {
+		if m.Type != container.MountTypeVolume {
+			continue
+		}
+
+		v, err := volume.Acquire(m.Name, config.name)
+		if err != nil {
+			config.releaseVolumes()
+			return err
+		}
+		config.volumes = append(config.volumes, v.Name)
+		mounts[i].Name, mounts[i].Source = v.Name, v.Mountpoint
+	}
+	config.mounts = mounts
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"docker/internal/runc/volume"
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+var VolumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "manage named volumes",
+	Subcommands: []cli.Command{
+		{
+			Name: "create",
+			Usage: `create a volume, a random name is used without name
+			minidocker volume create [-d driver] [name]`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "d, driver",
+					Usage: "volume driver, local or a plugin listening in the plugins directory",
+				},
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set a key=value label on the volume",
+				},
+				cli.StringSliceFlag{
+					Name:  "o, opt",
+					Usage: "set a key=value driver option",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) > 1 {
+					return fmt.Errorf("minidocker: volume create takes at most one name")
+				}
+
+				return volume.RunVolumeCreate(context.Args().First(), context.String("driver"), context.StringSlice("label"), context.StringSlice("opt"))
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "list volumes",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) != 0 {
+					return fmt.Errorf("minidocker: no args needed for volume ls")
+				}
+
+				return volume.RunVolumeList()
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "show the details of volumes",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) == 0 {
+					return fmt.Errorf("minidocker: at least one volume is needed for volume inspect")
+				}
+
+				return volume.RunVolumeInspect(context.Args())
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove volumes which no container uses",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "f, force",
+					Usage: "do not fail on missing volumes",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) == 0 {
+					return fmt.Errorf("minidocker: at least one volume is needed for volume rm")
+				}
+
+				return volume.RunVolumeRemove(context.Args(), context.Bool("force"))
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove the volumes no container uses",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) != 0 {
+					return fmt.Errorf("minidocker: no args needed for volume prune")
+				}
+
+				return volume.RunVolumePrune()
+			},
+		},
+	},
+}
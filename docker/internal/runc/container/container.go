@@ -3,7 +3,11 @@ package container
 import (
 	"bufio"
 	"docker/internal/runc/image"
+	"docker/internal/runc/storage"
+	"docker/internal/runc/volume"
+	"docker/internal/utils/archive"
 	"docker/internal/utils/cmdtable"
+	"docker/internal/utils/config"
 	"docker/internal/utils/path"
 	"docker/internal/utils/pipe"
 	"encoding/json"
@@ -23,34 +27,52 @@ import (
 )
 
 const (
-	imagedir = "/root/go/src/miniDocker/docker/cmd/docker-nwmgmt.tar"
-	lowerdir = "/root/go/src/miniDocker/docker/cmd/docker-nwmgmt"
-	Upperdir = "/root/go/src/miniDocker/docker/cmd/diff"
-	Workdir  = "/root/go/src/miniDocker/docker/cmd/work"
-	Mergedir = "/root/go/src/miniDocker/docker/cmd/merged"
-
 	RUNNING = "running"
 	STOP    = "stop"
 	EXIT    = "exit"
 
-	defaultContainerInfoPath = "/var/run/minidocker"
-	configName               = "config.json"
-	logName                  = "container.log"
+	configName = "config.json"
+	logName    = "container.log"
 
 	ENV_EXEC_PID = "minidocker_pid"
 	ENV_EXEC_CMD = "minidocker_cmd"
 )
 
+// InitConfig is sent by the parent through the pipe to the container init
+// process, the environment is passed as the init process environment.
+type InitConfig struct {
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"workingDir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Mounts     []Mount  `json:"mounts,omitempty"`
+}
+
 type Container struct {
-	Pid         string `json:"pid"`
-	Name        string `json:"name"`
-	Status      string `json:"status"`
-	Command     string `json:"command"`
-	CreatedTime string `json:"created"`
+	Pid         string   `json:"pid"`
+	Name        string   `json:"name"`
+	Image       string   `json:"image"`
+	ImageID     string   `json:"imageId"`
+	Layers      []string `json:"layers,omitempty"`
+	Driver      string   `json:"driver,omitempty"`
+	Volumes     []string `json:"volumes,omitempty"`
+	Mounts      []Mount  `json:"mounts,omitempty"`
+	Status      string   `json:"status"`
+	Command     string   `json:"command"`
+	CreatedTime string   `json:"created"`
 	config      string
 }
 
-func createInitCommand(name string, tty bool, readPipe *os.File, envs []string) *exec.Cmd {
+// containerInfoPath is the runtime directory holding the state of containers.
+func containerInfoPath() string {
+	return config.RootPath("containers")
+}
+
+// StorageDriver returns the driver which holds the rootfs of the container.
+func (c *Container) StorageDriver() (storage.Driver, error) {
+	return storage.GetDriver(c.Driver)
+}
+
+func createInitCommand(name, rootfs string, tty bool, readPipe *os.File, envs []string) *exec.Cmd {
 	cmd := exec.Command("/proc/self/exe", "init")
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
@@ -61,7 +83,7 @@ func createInitCommand(name string, tty bool, readPipe *os.File, envs []string)
 		cmd.Stderr = os.Stderr
 		cmd.Stdout = os.Stdout
 	} else {
-		containerlog := fmt.Sprintf("%s/%s/%s", defaultContainerInfoPath, name, logName)
+		containerlog := fmt.Sprintf("%s/%s/%s", containerInfoPath(), name, logName)
 		file, _ := os.OpenFile(containerlog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, os.ModePerm)
 		defer func() { file.Close() }()
 
@@ -70,42 +92,53 @@ func createInitCommand(name string, tty bool, readPipe *os.File, envs []string)
 		cmd.Stdout = file
 	}
 
+	// the container starts with a clean environment built from the image
+	// config and -e instead of the host environment
 	cmd.ExtraFiles = []*os.File{readPipe}
-	cmd.Env = append(os.Environ(), envs...)
-	cmd.Dir = Mergedir
+	cmd.Env = envs
+	cmd.Dir = rootfs
 
 	return cmd
 }
 
-func createOverlayFilesystem(volume string) {
-	if volume != "" && len(strings.Split(volume, ":")) == 2 {
-		image.NewOverlayFilesystemWithVolume(imagedir, lowerdir, Upperdir, Workdir, Mergedir, volume)
-	} else {
-		image.NewOverlayFilesystem(imagedir, lowerdir, Upperdir, Workdir, Mergedir)
+// createRootfs creates the writable rootfs of the container on top of the
+// image layers.
+func createRootfs(driver storage.Driver, name string, options *storage.Options, lowerdirs []string) (string, error) {
+	if err := driver.Create(name, lowerdirs, options); err != nil {
+		driver.Remove(name)
+		return "", err
 	}
+
+	rootfs, err := driver.Mount(name)
+	if err != nil {
+		driver.Remove(name)
+		return "", err
+	}
+
+	return rootfs, nil
 }
 
-func NewParentProcess(tty bool, volume, name string, envs []string) (*exec.Cmd, *os.File, error) {
+func NewParentProcess(tty bool, name string, driver storage.Driver, options *storage.Options, lowerdirs []string, envs []string) (*exec.Cmd, *os.File, error) {
 	readPipe, writePipe, err := pipe.NewPipe()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	createOverlayFilesystem(volume)
-
-	return createInitCommand(name, tty, readPipe, envs), writePipe, nil
-}
-
-func pivotRoot(root string) error {
-	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
-		return err
+	containerPath := filepath.Join(containerInfoPath(), name)
+	if err := os.MkdirAll(containerPath, 0755); err != nil {
+		return nil, nil, fmt.Errorf("minidocker: create container path failed [%v]", err)
 	}
 
-	// Mount the new root as a new filesystem
-	if err := syscall.Mount(root, root, "", syscall.MS_BIND|syscall.MS_PRIVATE, ""); err != nil {
-		return fmt.Errorf("minidocker: mount rootfs to itself failed [%v]", err)
+	rootfs, err := createRootfs(driver, name, options, lowerdirs)
+	if err != nil {
+		os.RemoveAll(containerPath)
+		return nil, nil, err
 	}
 
+	return createInitCommand(name, rootfs, tty, readPipe, envs), writePipe, nil
+}
+
+func pivotRoot(root string) error {
 	// Create a new directory for the old root
 	pivotDir := filepath.Join(root, ".pivot_root")
 	if err := os.Mkdir(pivotDir, 0700); err != nil {
@@ -122,8 +155,12 @@ func pivotRoot(root string) error {
 		return fmt.Errorf("minidocker: chdir the current directory failed [%v]", err)
 	}
 
-	// Unmount the old root
+	// Unmount the old root, as a slave so that the unmount does not
+	// propagate to the host when the root is shared
 	pivotDir = filepath.Join("/", ".pivot_root")
+	if err := syscall.Mount("", pivotDir, "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("minidocker: make pivot_root directory slave failed [%v]", err)
+	}
 	if err := syscall.Unmount(pivotDir, syscall.MNT_DETACH); err != nil {
 		return fmt.Errorf("minidocker: umount pivot_root directory fialed [%v]", err)
 	}
@@ -131,13 +168,32 @@ func pivotRoot(root string) error {
 	return os.Remove(pivotDir)
 }
 
-func setupMount() error {
+// setupMount mounts the rootfs and the volumes of the container inside its
+// mount namespace and changes the root to it.
+func setupMount(mounts []Mount) error {
 	pwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("minidocker: get current location failed [%v]", err)
 	}
 
 	log.Infof("current locaion: %v", pwd)
+	if err := syscall.Mount("", "/", "", rootPropagation(mounts), ""); err != nil {
+		return err
+	}
+
+	if err := makeParentPrivate(pwd); err != nil {
+		return fmt.Errorf("minidocker: make the parent mount of rootfs private failed [%v]", err)
+	}
+
+	// Mount the new root as a new filesystem
+	if err := syscall.Mount(pwd, pwd, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("minidocker: mount rootfs to itself failed [%v]", err)
+	}
+
+	if err := setupMounts(pwd, mounts); err != nil {
+		return err
+	}
+
 	if err := pivotRoot(pwd); err != nil {
 		return fmt.Errorf("minidocker: change root filesystem failed [%v]", err)
 	}
@@ -149,17 +205,42 @@ func setupMount() error {
 	return nil
 }
 
-func readUserCommands() ([]string, error) {
+func readInitConfig() (*InitConfig, error) {
 	msg, err := io.ReadAll(os.NewFile(uintptr(3), "pipe"))
 	if err != nil {
 		return nil, fmt.Errorf("minidocker: read pipe error %v", err)
 	}
 
-	return strings.Split(string(msg), " "), nil
+	var initConfig InitConfig
+	if err := json.Unmarshal(msg, &initConfig); err != nil {
+		return nil, fmt.Errorf("minidocker: parse init config failed [%v]", err)
+	}
+
+	if len(initConfig.Args) == 0 {
+		return nil, fmt.Errorf("minidocker: no command specified")
+	}
+
+	return &initConfig, nil
+}
+
+func setupWorkingDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("minidocker: create working dir %v failed [%v]", dir, err)
+	}
+
+	if err := syscall.Chdir(dir); err != nil {
+		return fmt.Errorf("minidocker: chdir working dir %v failed [%v]", dir, err)
+	}
+
+	return nil
 }
 
 func RunContainerLog(name string) error {
-	containerlog := fmt.Sprintf("%s/%s/%s", defaultContainerInfoPath, name, logName)
+	containerlog := fmt.Sprintf("%s/%s/%s", containerInfoPath(), name, logName)
 	exist, err := path.PathExist(containerlog)
 	if err != nil {
 		return err
@@ -181,7 +262,7 @@ func RunContainerLog(name string) error {
 }
 
 func RunContainerRemove(name string) error {
-	config := fmt.Sprintf("%s/%s/%s", defaultContainerInfoPath, name, configName)
+	config := fmt.Sprintf("%s/%s/%s", containerInfoPath(), name, configName)
 	content, _ := os.ReadFile(config)
 	var container Container
 	json.Unmarshal(content, &container)
@@ -192,7 +273,23 @@ func RunContainerRemove(name string) error {
 		}
 	}
 
-	containerpath := fmt.Sprintf("%s/%s", defaultContainerInfoPath, name)
+	// The rootfs is still mounted for detached containers, the driver
+	// unmounts it before removing the container layer.
+	driver, err := container.StorageDriver()
+	if err != nil {
+		return err
+	}
+	if err := driver.Remove(name); err != nil {
+		return err
+	}
+
+	for _, v := range container.Volumes {
+		if err := volume.Release(v, container.Name); err != nil {
+			log.Errorf("release volume %v failed: %v", v, err)
+		}
+	}
+
+	containerpath := fmt.Sprintf("%s/%s", containerInfoPath(), name)
 	if err := os.RemoveAll(containerpath); err != nil {
 		return err
 	}
@@ -201,7 +298,7 @@ func RunContainerRemove(name string) error {
 }
 
 func RunContainerStop(name string) error {
-	config := fmt.Sprintf("%s/%s/%s", defaultContainerInfoPath, name, configName)
+	config := fmt.Sprintf("%s/%s/%s", containerInfoPath(), name, configName)
 	content, _ := os.ReadFile(config)
 	var container Container
 	if err := json.Unmarshal(content, &container); err != nil {
@@ -214,6 +311,12 @@ func RunContainerStop(name string) error {
 		return err
 	}
 
+	for _, v := range container.Volumes {
+		if err := volume.Unmount(v, container.Name); err != nil {
+			log.Errorf("unmount volume %v failed: %v", v, err)
+		}
+	}
+
 	container.Status = STOP
 	file, _ := os.OpenFile(config, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0622)
 
@@ -225,18 +328,109 @@ func RunContainerStop(name string) error {
 	return nil
 }
 
-func RunContainerCommit(imageName string) error {
-	imageTar := imageName + ".tar"
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", Mergedir, ".").CombinedOutput(); err != nil {
-		log.Errorf("commit container into %v failed: %v", imageTar, err)
+// RunContainerCommit stores the diff of the container as a new image on top
+// of the image the container runs.
+func RunContainerCommit(containerName, ref string, options *image.CommitOptions) error {
+	c, err := loadContainerInfo(containerName)
+	if err != nil {
+		return fmt.Errorf("minidocker: get container %v failed [%v]", containerName, err)
+	}
+
+	driver, err := c.StorageDriver()
+	if err != nil {
+		return err
+	}
+
+	diff := storage.DiffReader(driver, containerName)
+	defer diff.Close()
+
+	img, err := image.Commit(c.ImageID, diff, ref, options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(img.ID)
+	return nil
+}
+
+// containerInspect is the output of inspect, the container info with the
+// usage of its writable layer.
+type containerInspect struct {
+	*Container
+	Storage struct {
+		Driver string `json:"driver"`
+		*storage.Usage
+	} `json:"storage"`
+}
+
+func RunContainerInspect(name string) error {
+	c, err := loadContainerInfo(name)
+	if err != nil {
+		return fmt.Errorf("minidocker: get container %v failed [%v]", name, err)
+	}
+
+	driver, err := c.StorageDriver()
+	if err != nil {
+		return err
+	}
+
+	inspect := &containerInspect{Container: c}
+	inspect.Storage.Driver = driver.Name()
+	if inspect.Storage.Usage, err = driver.Usage(name); err != nil {
+		return fmt.Errorf("minidocker: get storage usage of %v failed [%v]", name, err)
+	}
+
+	content, err := json.MarshalIndent(inspect, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(content))
+	return nil
+}
+
+// RunContainerDiff prints the paths the container added, changed or
+// deleted, one "A /path" per line or as json.
+func RunContainerDiff(name, format string) error {
+	if format != "" && format != "json" {
+		return fmt.Errorf("minidocker: unknown format %v", format)
+	}
+
+	c, err := loadContainerInfo(name)
+	if err != nil {
+		return fmt.Errorf("minidocker: get container %v failed [%v]", name, err)
+	}
+
+	driver, err := c.StorageDriver()
+	if err != nil {
+		return err
+	}
+
+	changes, err := driver.Changes(name)
+	if err != nil {
+		return fmt.Errorf("minidocker: get changes of %v failed [%v]", name, err)
+	}
+
+	if format == "json" {
+		if changes == nil {
+			changes = []archive.Change{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(changes)
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Kind, change.Path)
 	}
 
 	return nil
 }
 
 func getContainerInfo(file os.FileInfo) (*Container, error) {
-	name := file.Name()
-	config := fmt.Sprintf("%s/%s/%s", defaultContainerInfoPath, name, configName)
+	return loadContainerInfo(file.Name())
+}
+
+func loadContainerInfo(name string) (*Container, error) {
+	config := fmt.Sprintf("%s/%s/%s", containerInfoPath(), name, configName)
 	content, err := os.ReadFile(config)
 	if err != nil {
 		return nil, err
@@ -251,11 +445,14 @@ func getContainerInfo(file os.FileInfo) (*Container, error) {
 	return &container, nil
 }
 
-func RunContainerList(flag bool) error {
-	files, err := ioutil.ReadDir(defaultContainerInfoPath)
+// ListContainers returns the containers of all states.
+func ListContainers() ([]*Container, error) {
+	files, err := ioutil.ReadDir(containerInfoPath())
 	if err != nil {
-		log.Errorf("read container config failed: %v", err)
-		return err
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
 	}
 
 	var containers []*Container
@@ -265,27 +462,66 @@ func RunContainerList(flag bool) error {
 			log.Errorf("get container info failed: %v", err)
 			continue
 		}
+		containers = append(containers, c)
+	}
 
-		if flag {
-			containers = append(containers, c)
-		} else {
-			if c.Status == RUNNING {
-				containers = append(containers, c)
+	return containers, nil
+}
+
+// ImageReferences collects the images and layers the containers use, they
+// are kept by rmi and image prune.
+func ImageReferences() (*image.References, error) {
+	containers, err := ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := &image.References{Containers: map[string][]string{}, Layers: map[string]bool{}}
+	for _, c := range containers {
+		if c.ImageID == "" {
+			continue
+		}
+		refs.Containers[c.ImageID] = append(refs.Containers[c.ImageID], c.Name)
+
+		layers := c.Layers
+		if len(layers) == 0 {
+			if img, err := image.Lookup(c.ImageID); err == nil {
+				layers = img.RootFS.DiffIDs
 			}
 		}
+		for _, layer := range layers {
+			refs.Layers[layer] = true
+		}
+	}
+
+	return refs, nil
+}
+
+func RunContainerList(flag bool) error {
+	all, err := ListContainers()
+	if err != nil {
+		log.Errorf("read container config failed: %v", err)
+		return err
+	}
+
+	var containers []*Container
+	for _, c := range all {
+		if flag || c.Status == RUNNING {
+			containers = append(containers, c)
+		}
 	}
 
 	table := uitable.New()
-	table.AddRow("NAME", "COMMAND", "CREATED", "STATUS", "PID")
+	table.AddRow("NAME", "IMAGE", "COMMAND", "CREATED", "STATUS", "PID")
 	for _, container := range containers {
-		table.AddRow(container.Name, container.Command, container.CreatedTime, container.Status, container.Pid)
+		table.AddRow(container.Name, container.Image, container.Command, container.CreatedTime, container.Status, container.Pid)
 	}
 
 	return cmdtable.EncodeTable(os.Stdout, table)
 }
 
 func getContainerPid(name string) (string, error) {
-	config := fmt.Sprintf("%s/%s/%s", defaultContainerInfoPath, name, configName)
+	config := fmt.Sprintf("%s/%s/%s", containerInfoPath(), name, configName)
 	content, _ := os.ReadFile(config)
 
 	var container Container
@@ -329,18 +565,27 @@ func RunContainerExec(containerName string, commands []string) error {
 }
 
 func RunContainerInitProcess() error {
-	commands, err := readUserCommands()
+	initConfig, err := readInitConfig()
 	if err != nil {
 		return err
 	}
 
-	if err := setupMount(); err != nil {
+	if err := setupMount(initConfig.Mounts); err != nil {
+		return err
+	}
+
+	if err := setupWorkingDir(initConfig.WorkingDir); err != nil {
+		return err
+	}
+
+	if err := setupUser(initConfig.User); err != nil {
 		return err
 	}
 
+	commands := initConfig.Args
 	command, err := exec.LookPath(commands[0])
 	if err != nil {
-		return fmt.Errorf("minidocker: look command [%v] failed [%v]", command, err)
+		return fmt.Errorf("minidocker: look command [%v] failed [%v]", commands[0], err)
 	}
 
 	return syscall.Exec(command, commands[0:], os.Environ())
@@ -368,7 +613,7 @@ func (c *Container) RecordContainerInfo() error {
 		return fmt.Errorf("minidocker: record container info failed [%v]", err)
 	}
 
-	containerInfoPath := fmt.Sprintf("%s/%s", defaultContainerInfoPath, c.Name)
+	containerInfoPath := fmt.Sprintf("%s/%s", containerInfoPath(), c.Name)
 	if err := os.MkdirAll(containerInfoPath, 0622); err != nil {
 		return fmt.Errorf("minidocker: create container path failed [%v]", err)
 	}
@@ -391,10 +636,11 @@ func (c *Container) RecordContainerInfo() error {
 	return nil
 }
 
-func New(name, pid, command, status string) *Container {
+func New(name, pid, image, command, status string) *Container {
 	return &Container{
 		Name:        name,
 		Pid:         pid,
+		Image:       image,
 		Command:     command,
 		Status:      status,
 		CreatedTime: time.Now().Format(time.RFC3339),
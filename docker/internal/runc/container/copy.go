@@ -0,0 +1,268 @@
+package container
+
+import (
+	"docker/internal/utils/archive"
+	upath "docker/internal/utils/path"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// parseCopyPath splits CONTAINER:PATH, an argument with a slash before the
+// colon is a host path.
+func parseCopyPath(arg string) (string, string) {
+	if name, p, ok := strings.Cut(arg, ":"); ok && name != "" && !strings.Contains(name, "/") {
+		return name, p
+	}
+
+	return "", arg
+}
+
+// containerRootfs returns the rootfs of the container on the host, it is
+// mounted again if needed, e.g. for a container stopped before a reboot.
+func containerRootfs(name string) (string, error) {
+	c, err := loadContainerInfo(name)
+	if err != nil {
+		return "", fmt.Errorf("minidocker: get container %v failed [%v]", name, err)
+	}
+
+	driver, err := c.StorageDriver()
+	if err != nil {
+		return "", err
+	}
+
+	rootfs, err := driver.Mount(name)
+	if err != nil {
+		return "", fmt.Errorf("minidocker: mount rootfs of %v failed [%v]", name, err)
+	}
+
+	return rootfs, nil
+}
+
+// containerFS is the tree a container sees, its rootfs with the bind and
+// volume mounts recorded for it, as found on the host.
+type containerFS struct {
+	rootfs string
+	mounts []Mount
+}
+
+func loadContainerFS(name string) (*containerFS, error) {
+	rootfs, err := containerRootfs(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := loadContainerInfo(name)
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: get container %v failed [%v]", name, err)
+	}
+
+	fs := &containerFS{rootfs: rootfs}
+	for _, m := range c.Mounts {
+		// a tmpfs only exists inside the running container
+		if m.Type != MountTypeTmpfs {
+			fs.mounts = append(fs.mounts, m)
+		}
+	}
+
+	return fs, nil
+}
+
+// hostPath maps a resolved path of the container to the host, a path under
+// a mount, the deepest one, is under its source.
+func (fs *containerFS) hostPath(p string) string {
+	p = path.Clean("/" + p)
+	host, depth := filepath.Join(fs.rootfs, p), -1
+	for _, m := range fs.mounts {
+		rel := strings.TrimPrefix(p, m.Destination)
+		if !strings.HasPrefix(p, m.Destination) || (rel != "" && !strings.HasPrefix(rel, "/")) {
+			continue
+		}
+		if d := strings.Count(m.Destination, "/"); d > depth {
+			host, depth = filepath.Join(m.Source, rel), d
+		}
+	}
+
+	return host
+}
+
+// resolve resolves the symlinks of p inside the container and returns the
+// resolved path and its host path.
+func (fs *containerFS) resolve(p string) (string, string, error) {
+	resolved, err := upath.FollowSymlink(fs.hostPath, p)
+	if err != nil {
+		return "", "", err
+	}
+
+	return resolved, fs.hostPath(resolved), nil
+}
+
+// copyContent tells whether the content of the source directory is copied
+// rather than the directory itself, as asked by a trailing "/.".
+func copyContent(src string) bool {
+	return strings.HasSuffix(src, "/.") || src == "."
+}
+
+// copyTarget resolves where the source named base lands for dst, dst is
+// already resolved on the host. An existing directory receives the source
+// under its own name, otherwise the source is created as dst.
+func copyTarget(dst, base string, content, dstIsDir bool) (string, string, error) {
+	info, err := os.Stat(dst)
+	switch {
+	case err == nil && info.IsDir():
+		if content {
+			return dst, "", nil
+		}
+		return dst, base, nil
+	case err == nil:
+		if content {
+			return "", "", fmt.Errorf("minidocker: can not copy a directory to the file %v", dst)
+		}
+		return filepath.Dir(dst), filepath.Base(dst), nil
+	case !os.IsNotExist(err):
+		return "", "", err
+	case dstIsDir && !content:
+		return "", "", fmt.Errorf("minidocker: the destination directory %v does not exist", dst)
+	}
+
+	parent, err := os.Stat(filepath.Dir(dst))
+	if err != nil || !parent.IsDir() {
+		return "", "", fmt.Errorf("minidocker: the parent of %v is not a directory", dst)
+	}
+
+	if content {
+		if err := os.Mkdir(dst, 0755); err != nil {
+			return "", "", err
+		}
+		return dst, "", nil
+	}
+
+	return filepath.Dir(dst), filepath.Base(dst), nil
+}
+
+// copyFiles streams src as the entry name into the directory dir, modes,
+// ownership and xattrs are kept. The options tell how the entries resolve.
+func copyFiles(src, dir, name string, options *archive.Options) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(archive.TarPath(src, name, w, nil))
+	}()
+	defer r.Close()
+
+	return archive.Untar(r, dir, options)
+}
+
+// RunContainerCopy copies files between the host and a container, one of
+// src and dst is CONTAINER:PATH. The paths inside the container resolve
+// their symlinks inside the container, across its mounts, the last element
+// of the source is not followed. A path under a mount is copied from or to
+// the mounted host directory or volume.
+func RunContainerCopy(src, dst string) error {
+	srcContainer, srcPath := parseCopyPath(src)
+	dstContainer, dstPath := parseCopyPath(dst)
+	if (srcContainer == "") == (dstContainer == "") {
+		return fmt.Errorf("minidocker: exactly one of the source and the destination must be CONTAINER:PATH")
+	}
+
+	content, dstIsDir := copyContent(srcPath), strings.HasSuffix(dstPath, "/")
+	base := path.Base(srcPath)
+
+	var fs *containerFS
+	var dstResolved string
+	if srcContainer != "" {
+		var err error
+		if fs, err = loadContainerFS(srcContainer); err != nil {
+			return err
+		}
+		if !path.IsAbs(srcPath) {
+			srcPath = "/" + srcPath
+		}
+
+		dir, host, err := fs.resolve(path.Dir(srcPath))
+		if err != nil {
+			return err
+		}
+		srcPath = fs.hostPath(path.Join(dir, base))
+		if content {
+			srcPath = host
+		}
+	} else {
+		var err error
+		if fs, err = loadContainerFS(dstContainer); err != nil {
+			return err
+		}
+
+		if dstResolved, dstPath, err = fs.resolve(dstPath); err != nil {
+			return err
+		}
+		if srcPath, err = filepath.Abs(srcPath); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Lstat(srcPath); err != nil {
+		return fmt.Errorf("minidocker: copy source %v failed [%v]", src, err)
+	}
+
+	dir, name, err := copyTarget(dstPath, base, content, dstIsDir)
+	if err != nil {
+		return err
+	}
+
+	var options *archive.Options
+	if dstContainer != "" {
+		// the entries resolve inside the container, not only inside dir
+		containerDir := dstResolved
+		if dir != dstPath {
+			containerDir = path.Dir(dstResolved)
+		}
+		options = &archive.Options{
+			Scope: func(entryDir string) (string, error) {
+				_, host, err := fs.resolve(path.Join(containerDir, entryDir))
+				return host, err
+			},
+		}
+	}
+
+	if err := copyFiles(srcPath, dir, name, options); err != nil {
+		return fmt.Errorf("minidocker: copy %v to %v failed [%v]", src, dst, err)
+	}
+
+	return nil
+}
+
+// RunContainerExport streams the rootfs of the container, running or
+// stopped, as a flat tarball to output or stdout.
+func RunContainerExport(name, output string) error {
+	rootfs, err := containerRootfs(name)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		if err := archive.Tar(rootfs, os.Stdout, nil); err != nil {
+			return fmt.Errorf("minidocker: export container %v failed [%v]", name, err)
+		}
+		return nil
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("minidocker: create %v failed [%v]", output, err)
+	}
+
+	if err := archive.Tar(rootfs, file, nil); err != nil {
+		file.Close()
+		return fmt.Errorf("minidocker: export container %v failed [%v]", name, err)
+	}
+
+	// a failed close leaves a truncated tarball
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("minidocker: write %v failed [%v]", output, err)
+	}
+
+	return nil
+}
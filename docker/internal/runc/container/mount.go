@@ -0,0 +1,324 @@
+package container
+
+import (
+	"bufio"
+	upath "docker/internal/utils/path"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+// Mount is a path of the host, a named volume or a tmpfs mounted into the
+// container, it is set up by the container init inside the mount namespace
+// of the container so it goes away with the container.
+type Mount struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"readOnly,omitempty"`
+	Propagation string `json:"propagation,omitempty"`
+	// NoCopy keeps an empty volume empty rather than seeding it with the
+	// content of the image.
+	NoCopy bool `json:"noCopy,omitempty"`
+	// TmpfsSize and TmpfsMode configure a tmpfs, zero is the kernel default.
+	TmpfsSize int64       `json:"tmpfsSize,omitempty"`
+	TmpfsMode os.FileMode `json:"tmpfsMode,omitempty"`
+}
+
+var propagationFlags = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+}
+
+// IsPropagation tells whether the option of -v is a mount propagation.
+func IsPropagation(option string) bool {
+	_, ok := propagationFlags[option]
+	return ok
+}
+
+// rootPropagation returns the propagation of the mounts the container
+// namespace copied from the host. They stay peers of the host when a mount
+// is shared, so mounts propagate both ways, and slaves when a mount only
+// receives the mounts of the host. Otherwise they are private.
+func rootPropagation(mounts []Mount) uintptr {
+	propagation := uintptr(syscall.MS_PRIVATE | syscall.MS_REC)
+	for _, m := range mounts {
+		if strings.Contains(m.Propagation, "shared") {
+			return syscall.MS_SHARED | syscall.MS_REC
+		}
+		if strings.Contains(m.Propagation, "slave") {
+			propagation = syscall.MS_SLAVE | syscall.MS_REC
+		}
+	}
+
+	return propagation
+}
+
+// mountPoints returns the mount points of the mount namespace.
+func mountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var points []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// the fifth field is the mount point, spaces are escaped as \040
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 {
+			points = append(points, strings.ReplaceAll(fields[4], "\\040", " "))
+		}
+	}
+
+	return points, scanner.Err()
+}
+
+// makeParentPrivate makes the mount holding dir private, pivot_root refuses
+// a new root whose parent mount is shared, as it is when the namespace
+// stays a peer of the host.
+func makeParentPrivate(dir string) error {
+	points, err := mountPoints()
+	if err != nil {
+		return err
+	}
+
+	parent := "/"
+	for _, point := range points {
+		if (dir == point || strings.HasPrefix(dir, point+"/")) && len(point) > len(parent) {
+			parent = point
+		}
+	}
+
+	return syscall.Mount("", parent, "", syscall.MS_PRIVATE, "")
+}
+
+// remountReadOnly remounts target and every mount below it read-only, a
+// read-only remount only applies to a single mount.
+func remountReadOnly(target string) error {
+	points, err := mountPoints()
+	if err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for _, point := range points {
+		if point != target && !strings.HasPrefix(point, target+"/") {
+			continue
+		}
+		if err := syscall.Mount("", point, "", flags, ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createMountPoint creates the destination in the rootfs, a file for a file
+// source and a directory otherwise.
+func createMountPoint(target, source string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return os.MkdirAll(target, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	return len(entries) == 0, nil
+}
+
+// copyImageContent seeds an empty volume with the content of the image at
+// its destination, the volume takes the ownership and mode of the
+// destination like the entries it receives.
+func copyImageContent(target, volume string) error {
+	info, err := os.Stat(target)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	if empty, err := isEmptyDir(volume); err != nil || !empty {
+		return err
+	}
+	if empty, err := isEmptyDir(target); err != nil || empty {
+		return err
+	}
+
+	if err := copyFiles(target, volume, "", nil); err != nil {
+		return err
+	}
+
+	stat := info.Sys().(*syscall.Stat_t)
+	if err := os.Lchown(volume, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+
+	return os.Chmod(volume, info.Mode())
+}
+
+// mountBind bind mounts the source onto its destination in root, the
+// destination resolves its symlinks inside root.
+func mountBind(root string, m Mount) error {
+	target, err := upath.FollowSymlinkInScope(root, m.Destination)
+	if err != nil {
+		return err
+	}
+
+	if m.Type == MountTypeVolume && !m.NoCopy {
+		if err := copyImageContent(target, m.Source); err != nil {
+			return fmt.Errorf("minidocker: copy %v into volume %v failed [%v]", m.Destination, m.Name, err)
+		}
+	}
+
+	if err := createMountPoint(target, m.Source); err != nil {
+		return fmt.Errorf("minidocker: create mount point %v failed [%v]", m.Destination, err)
+	}
+
+	if err := syscall.Mount(m.Source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("minidocker: bind mount %v to %v failed [%v]", m.Source, m.Destination, err)
+	}
+
+	if m.ReadOnly {
+		if err := remountReadOnly(target); err != nil {
+			return fmt.Errorf("minidocker: remount %v read-only failed [%v]", m.Destination, err)
+		}
+	}
+
+	propagation := m.Propagation
+	if propagation == "" {
+		propagation = "rprivate"
+	}
+	if err := syscall.Mount("", target, "", propagationFlags[propagation], ""); err != nil {
+		return fmt.Errorf("minidocker: set propagation %v of %v failed [%v]", propagation, m.Destination, err)
+	}
+
+	return nil
+}
+
+func depth(dst string) int {
+	return strings.Count(filepath.Clean("/"+dst), "/")
+}
+
+// tmpfsData returns the mount data of the tmpfs.
+func tmpfsData(m Mount) string {
+	var data []string
+	if m.TmpfsSize != 0 {
+		data = append(data, "size="+strconv.FormatInt(m.TmpfsSize, 10))
+	}
+	if m.TmpfsMode != 0 {
+		data = append(data, "mode="+strconv.FormatUint(uint64(m.TmpfsMode), 8))
+	}
+
+	return strings.Join(data, ",")
+}
+
+// mountTmpfs mounts a tmpfs onto its destination in root, its content is
+// never backed by the writable layer.
+func mountTmpfs(root string, m Mount) error {
+	target, err := upath.FollowSymlinkInScope(root, m.Destination)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("minidocker: create mount point %v failed [%v]", m.Destination, err)
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+	if m.ReadOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := syscall.Mount("tmpfs", target, "tmpfs", flags, tmpfsData(m)); err != nil {
+		return fmt.Errorf("minidocker: mount tmpfs on %v failed [%v]", m.Destination, err)
+	}
+
+	return nil
+}
+
+// setupMounts mounts the volumes and tmpfs of the container into root
+// before pivot_root, the mounts of the parent destinations first.
+func setupMounts(root string, mounts []Mount) error {
+	sorted := append([]Mount{}, mounts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return depth(sorted[i].Destination) < depth(sorted[j].Destination)
+	})
+
+	for _, m := range sorted {
+		var err error
+		switch m.Type {
+		case MountTypeBind, MountTypeVolume:
+			err = mountBind(root, m)
+		case MountTypeTmpfs:
+			err = mountTmpfs(root, m)
+		default:
+			err = fmt.Errorf("minidocker: unknown mount type %v", m.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VolumesFrom returns the bind and volume mounts recorded for the container
+// of CONTAINER[:ro|rw], the mode overrides the one of every mount.
+func VolumesFrom(spec string) ([]Mount, error) {
+	name, mode, _ := strings.Cut(spec, ":")
+	if mode != "" && mode != "ro" && mode != "rw" {
+		return nil, fmt.Errorf("minidocker: invalid mode %v in %v", mode, spec)
+	}
+
+	c, err := loadContainerInfo(name)
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: get container %v failed [%v]", name, err)
+	}
+
+	var mounts []Mount
+	for _, m := range c.Mounts {
+		if m.Type == MountTypeTmpfs {
+			continue
+		}
+		if mode != "" {
+			m.ReadOnly = mode == "ro"
+		}
+		mounts = append(mounts, m)
+	}
+
+	return mounts, nil
+}
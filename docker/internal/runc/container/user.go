@@ -0,0 +1,150 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type execUser struct {
+	uid    int
+	gid    int
+	groups []int
+	home   string
+}
+
+// parseDatabase reads colon separated entries such as /etc/passwd, a
+// missing file is an empty database.
+func parseDatabase(file string) ([][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+
+	return entries, scanner.Err()
+}
+
+func lookupGroup(group string) (int, error) {
+	groups, err := parseDatabase("/etc/group")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range groups {
+		if len(entry) >= 3 && (entry[0] == group || entry[2] == group) {
+			return strconv.Atoi(entry[2])
+		}
+	}
+
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	return 0, fmt.Errorf("minidocker: unable to find group %v", group)
+}
+
+// lookupUser resolves user[:group] against the container /etc/passwd and
+// /etc/group, numeric ids are accepted without an entry. A uid without an
+// entry runs with gid 0 unless the group is given, as docker does.
+func lookupUser(spec string) (*execUser, error) {
+	user := &execUser{home: "/"}
+
+	name, group, hasGroup := strings.Cut(spec, ":")
+	passwd, err := parseDatabase("/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+
+	// the members of /etc/group are user names, a uid is resolved first
+	userName := ""
+	for _, entry := range passwd {
+		if len(entry) >= 6 && (entry[0] == name || entry[2] == name) {
+			user.uid, _ = strconv.Atoi(entry[2])
+			user.gid, _ = strconv.Atoi(entry[3])
+			user.home = entry[5]
+			userName = entry[0]
+			break
+		}
+	}
+
+	if userName == "" {
+		uid, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, fmt.Errorf("minidocker: unable to find user %v", name)
+		}
+		user.uid = uid
+	}
+
+	if hasGroup {
+		if user.gid, err = lookupGroup(group); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := parseDatabase("/etc/group")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range groups {
+		if len(entry) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(entry[3], ",") {
+			if userName != "" && member == userName {
+				if gid, err := strconv.Atoi(entry[2]); err == nil {
+					user.groups = append(user.groups, gid)
+				}
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// setupUser switches the init process to the user before exec, HOME is set
+// from the passwd entry unless the image sets it.
+func setupUser(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	user, err := lookupUser(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Setgroups(append([]int{user.gid}, user.groups...)); err != nil {
+		return fmt.Errorf("minidocker: set groups failed [%v]", err)
+	}
+
+	if err := syscall.Setgid(user.gid); err != nil {
+		return fmt.Errorf("minidocker: set gid %v failed [%v]", user.gid, err)
+	}
+
+	if err := syscall.Setuid(user.uid); err != nil {
+		return fmt.Errorf("minidocker: set uid %v failed [%v]", user.uid, err)
+	}
+
+	if _, exist := os.LookupEnv("HOME"); !exist {
+		os.Setenv("HOME", user.home)
+	}
+
+	return nil
+}
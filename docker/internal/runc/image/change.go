@@ -0,0 +1,179 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Config is the runtime config carried by the image, it is merged with the
+// command line of run.
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// Copy returns a deep copy so changes do not leak into the parent image.
+func (config Config) Copy() Config {
+	config.Env = append([]string(nil), config.Env...)
+	config.Entrypoint = append([]string(nil), config.Entrypoint...)
+	config.Cmd = append([]string(nil), config.Cmd...)
+
+	if config.ExposedPorts != nil {
+		ports := map[string]struct{}{}
+		for port := range config.ExposedPorts {
+			ports[port] = struct{}{}
+		}
+		config.ExposedPorts = ports
+	}
+
+	if config.Labels != nil {
+		labels := map[string]string{}
+		for key, value := range config.Labels {
+			labels[key] = value
+		}
+		config.Labels = labels
+	}
+
+	return config
+}
+
+// parseCommand accepts both the exec form ["a", "b"] and the shell form,
+// which is run by /bin/sh -c.
+func parseCommand(value string) []string {
+	var command []string
+	if strings.HasPrefix(value, "[") && json.Unmarshal([]byte(value), &command) == nil {
+		return command
+	}
+
+	return []string{"/bin/sh", "-c", value}
+}
+
+// splitFields splits on spaces which are not inside double quotes, the
+// quotes are removed.
+func splitFields(value string) []string {
+	var fields []string
+	var field strings.Builder
+	quoted, inField := false, false
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value):
+			i++
+			field.WriteByte(value[i])
+			inField = true
+		case c == '"':
+			quoted = !quoted
+			inField = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+// parseKeyValues accepts both "key=value ..." and the legacy "key value".
+func parseKeyValues(value string) (map[string]string, []string, error) {
+	pairs, keys := map[string]string{}, []string{}
+	if !strings.Contains(strings.Fields(value)[0], "=") {
+		fields := strings.SplitN(value, " ", 2)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("minidocker: missing value in %v", value)
+		}
+		return map[string]string{fields[0]: strings.TrimSpace(fields[1])}, []string{fields[0]}, nil
+	}
+
+	for _, field := range splitFields(value) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, nil, fmt.Errorf("minidocker: invalid key value %v", field)
+		}
+		pairs[kv[0]] = kv[1]
+		keys = append(keys, kv[0])
+	}
+
+	return pairs, keys, nil
+}
+
+// SetEnv sets the key in a KEY=VALUE list, keeping its position if present.
+func SetEnv(envs []string, key, value string) []string {
+	for i, env := range envs {
+		if strings.SplitN(env, "=", 2)[0] == key {
+			envs[i] = key + "=" + value
+			return envs
+		}
+	}
+
+	return append(envs, key+"="+value)
+}
+
+// ApplyChange applies a Dockerfile like instruction such as `CMD ["sh"]` or
+// `ENV KEY=VALUE` to the config.
+func (config *Config) ApplyChange(change string) error {
+	fields := strings.SplitN(strings.TrimSpace(change), " ", 2)
+	if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+		return fmt.Errorf("minidocker: invalid change %q", change)
+	}
+	instruction, value := strings.ToUpper(fields[0]), strings.TrimSpace(fields[1])
+
+	switch instruction {
+	case "CMD":
+		config.Cmd = parseCommand(value)
+	case "ENTRYPOINT":
+		config.Entrypoint = parseCommand(value)
+	case "WORKDIR":
+		config.WorkingDir = value
+	case "USER":
+		config.User = value
+	case "ENV":
+		pairs, keys, err := parseKeyValues(value)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			config.Env = SetEnv(config.Env, key, pairs[key])
+		}
+	case "LABEL":
+		pairs, _, err := parseKeyValues(value)
+		if err != nil {
+			return err
+		}
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for key, value := range pairs {
+			config.Labels[key] = value
+		}
+	case "EXPOSE":
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range strings.Fields(value) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	default:
+		return fmt.Errorf("minidocker: unsupported change instruction %v", instruction)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type CommitOptions struct {
+	Author  string
+	Message string
+	Changes []string
+	// Config replaces the parent config before the changes are applied.
+	Config *Config
+	// CreatedBy records the instruction which created the layer.
+	CreatedBy string
+}
+
+// Commit stores the diff of a container, a layer tarball written by its
+// storage driver, as a new layer on top of its parent image. The new image
+// is tagged as ref unless ref is empty.
+func Commit(parentID string, diff io.Reader, ref string, options *CommitOptions) (*Image, error) {
+	if ref != "" {
+		if _, _, err := ParseReference(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	layer, err := importLayer(diff, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return commitImage(parentID, layer, ref, options)
+}
+
+// CommitConfig creates a child image which only changes the config, it
+// is recorded as an empty layer in the history.
+func CommitConfig(parentID string, options *CommitOptions) (*Image, error) {
+	return commitImage(parentID, nil, "", options)
+}
+
+// scratch is the empty parent of images built FROM scratch.
+func scratch() *Image {
+	return &Image{
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS:       RootFS{Type: "layers"},
+	}
+}
+
+func commitImage(parentID string, layer *Layer, ref string, options *CommitOptions) (*Image, error) {
+	parent := scratch()
+	if parentID != "" {
+		var err error
+		if parent, err = loadImage(parentID); err != nil {
+			return nil, fmt.Errorf("minidocker: load parent image %v failed [%v]", parentID, err)
+		}
+	}
+
+	config := parent.Config.Copy()
+	if options.Config != nil {
+		config = options.Config.Copy()
+	}
+	for _, change := range options.Changes {
+		if err := config.ApplyChange(change); err != nil {
+			return nil, err
+		}
+	}
+
+	createdBy := options.CreatedBy
+	if createdBy == "" && len(options.Changes) != 0 {
+		createdBy = "minidocker commit --change " + strings.Join(options.Changes, " --change ")
+	}
+
+	diffIDs := append([]string{}, parent.RootFS.DiffIDs...)
+	if layer != nil {
+		diffIDs = append(diffIDs, layer.Digest)
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	img := &Image{
+		Parent:       parentID,
+		Created:      created,
+		Author:       options.Author,
+		Architecture: parent.Architecture,
+		OS:           parent.OS,
+		Config:       config,
+		RootFS: RootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+		History: append(append([]History{}, parent.History...), History{
+			Created:    created,
+			CreatedBy:  createdBy,
+			Author:     options.Author,
+			Comment:    options.Message,
+			EmptyLayer: layer == nil,
+		}),
+	}
+
+	if err := img.store(); err != nil {
+		return nil, fmt.Errorf("minidocker: store image failed [%v]", err)
+	}
+
+	if ref != "" {
+		if err := tag(img, ref); err != nil {
+			return nil, fmt.Errorf("minidocker: tag image %v failed [%v]", ref, err)
+		}
+	}
+
+	return img, nil
+}
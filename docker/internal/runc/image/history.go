@@ -0,0 +1,84 @@
+package image
+
+import (
+	"docker/internal/utils/cmdtable"
+	"os"
+	"strings"
+
+	"github.com/gosuri/uitable"
+)
+
+const createdByWidth = 45
+
+// historyIDs maps the history entries to the images of the parent chain,
+// an entry created outside this store such as a pulled layer is <missing>.
+func historyIDs(img *Image) []string {
+	ids := make([]string, len(img.History))
+	for i := range ids {
+		ids[i] = "<missing>"
+	}
+
+	for current := img; current != nil; {
+		if n := len(current.History); n > 0 && n <= len(ids) {
+			ids[n-1] = current.ID
+		}
+
+		if current.Parent == "" {
+			break
+		}
+		parent, err := loadImage(current.Parent)
+		if err != nil {
+			break
+		}
+		current = parent
+	}
+
+	return ids
+}
+
+// RunImageHistory lists the history of the image, the newest entry first.
+func RunImageHistory(ref string, noTrunc bool) error {
+	img, err := Lookup(ref)
+	if err != nil {
+		return err
+	}
+
+	ids := historyIDs(img)
+
+	// every entry which is not an empty layer created the next diff id
+	sizes := make([]int64, len(img.History))
+	layer := 0
+	for i, history := range img.History {
+		if history.EmptyLayer || layer >= len(img.RootFS.DiffIDs) {
+			continue
+		}
+		if l, err := loadLayer(img.RootFS.DiffIDs[layer]); err == nil {
+			sizes[i] = l.Size
+		}
+		layer++
+	}
+
+	table := uitable.New()
+	table.AddRow("IMAGE", "CREATED", "CREATED BY", "SIZE", "COMMENT")
+	for i := len(img.History) - 1; i >= 0; i-- {
+		history := img.History[i]
+
+		createdBy := strings.Join(strings.Fields(history.CreatedBy), " ")
+		if !noTrunc && len(createdBy) > createdByWidth {
+			createdBy = createdBy[:createdByWidth-3] + "..."
+		}
+
+		id := ids[i]
+		if id != "<missing>" {
+			if noTrunc {
+				id = digestPrefix + id
+			} else {
+				id = id[:12]
+			}
+		}
+
+		table.AddRow(id, history.Created, createdBy, HumanSize(sizes[i]), history.Comment)
+	}
+
+	return cmdtable.EncodeTable(os.Stdout, table)
+}
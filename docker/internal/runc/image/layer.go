@@ -0,0 +1,150 @@
+package image
+
+import (
+	"crypto/sha256"
+	"docker/internal/utils/archive"
+	"docker/internal/utils/path"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	digestPrefix = "sha256:"
+
+	layerTarName  = "layer.tar"
+	layerDiffName = "diff"
+	layerMetaName = "meta.json"
+)
+
+// layerOptions keeps the whiteouts of the extracted layers as overlay
+// whiteouts, the layers are used as overlay lowerdirs.
+var layerOptions = &archive.Options{OverlayWhiteouts: true}
+
+// Layer is a content addressable filesystem diff, the digest is the sha256
+// of the uncompressed layer tarball which is kept next to the extracted diff.
+type Layer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+func layerPath(digest string, elem ...string) string {
+	return storePath(append([]string{"layers", strings.TrimPrefix(digest, digestPrefix)}, elem...)...)
+}
+
+// LayerDiffPath is the extracted layer directory used as overlay lowerdir.
+func LayerDiffPath(digest string) string {
+	return layerPath(digest, layerDiffName)
+}
+
+func loadLayer(digest string) (*Layer, error) {
+	content, err := os.ReadFile(layerPath(digest, layerMetaName))
+	if err != nil {
+		return nil, err
+	}
+
+	var layer Layer
+	if err := json.Unmarshal(content, &layer); err != nil {
+		return nil, fmt.Errorf("minidocker: parse layer %v failed [%v]", digest, err)
+	}
+
+	return &layer, nil
+}
+
+// HasLayer tells whether the layer is already in the store.
+func HasLayer(digest string) bool {
+	_, err := loadLayer(digest)
+	return err == nil
+}
+
+// ImportLayer stores the layer tarball, its uncompressed content must match
+// the diff id.
+func ImportLayer(r io.Reader, diffID string) error {
+	_, err := importLayer(r, diffID)
+	return err
+}
+
+// importLayer stores a (possibly compressed) layer tarball into the layer
+// store, a layer which already exists is shared instead of extracted again.
+// A non empty diffID is verified against the uncompressed content.
+func importLayer(r io.Reader, diffID string) (*Layer, error) {
+	rc, err := archive.DecompressStream(r)
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: read layer failed [%v]", err)
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(storePath("layers"), 0755); err != nil {
+		return nil, err
+	}
+
+	tmpdir, err := os.MkdirTemp(storePath(), "layer-")
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: create temp layer failed [%v]", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	file, err := os.Create(filepath.Join(tmpdir, layerTarName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, h), rc); err != nil {
+		return nil, fmt.Errorf("minidocker: write layer failed [%v]", err)
+	}
+	digest := digestPrefix + hex.EncodeToString(h.Sum(nil))
+	if diffID != "" && diffID != digest {
+		return nil, fmt.Errorf("minidocker: layer digest %v does not match %v", digest, diffID)
+	}
+
+	if layer, err := loadLayer(digest); err == nil {
+		return layer, nil
+	}
+
+	diffdir := filepath.Join(tmpdir, layerDiffName)
+	if err := os.Mkdir(diffdir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := archive.UntarFile(file.Name(), diffdir, layerOptions); err != nil {
+		return nil, fmt.Errorf("minidocker: untar layer %v failed [%v]", digest, err)
+	}
+
+	layer := &Layer{Digest: digest, Size: dirSize(diffdir)}
+	content, err := json.MarshalIndent(layer, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, layerMetaName), content, 0644); err != nil {
+		return nil, err
+	}
+
+	// another import may have stored the same layer in the meantime
+	if exist, _ := path.PathExist(layerPath(digest)); exist {
+		return layer, nil
+	}
+
+	if err := os.Rename(tmpdir, layerPath(digest)); err != nil {
+		return nil, fmt.Errorf("minidocker: store layer %v failed [%v]", digest, err)
+	}
+
+	return layer, nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
@@ -0,0 +1,353 @@
+package image
+
+import (
+	"crypto/sha256"
+	"docker/internal/utils/archive"
+	"docker/internal/utils/path"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// securePath resolves name inside dir, neither ".." nor the symlinks of the
+// archive can lead out of dir.
+func securePath(dir, name string) (string, error) {
+	file, err := path.FollowSymlinkInScope(dir, name)
+	if err != nil {
+		return "", fmt.Errorf("minidocker: resolve %v in image archive failed [%v]", name, err)
+	}
+
+	return file, nil
+}
+
+// readArchiveFile reads the file name of the extracted archive in dir.
+func readArchiveFile(dir, name string) ([]byte, error) {
+	file, err := securePath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(file)
+}
+
+// extractArchive extracts the image archive into dir, the parents of every
+// entry are resolved inside dir so a chain of symlinks can not redirect an
+// entry out of it.
+func extractArchive(r io.Reader, dir string) error {
+	if err := archive.Untar(r, dir, nil); err != nil {
+		return fmt.Errorf("minidocker: extract image archive failed [%v]", err)
+	}
+
+	return nil
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return digestPrefix + hex.EncodeToString(sum[:])
+}
+
+// verifyFile checks the sha256 of the file against the digest.
+func verifyFile(file, digest string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if actual := digestPrefix + hex.EncodeToString(h.Sum(nil)); actual != digest {
+		return fmt.Errorf("minidocker: blob digest %v does not match %v", actual, digest)
+	}
+
+	return nil
+}
+
+// blobName is the location of a blob in the OCI image layout.
+func blobName(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join("blobs", algorithm, hex)
+}
+
+func readBlob(dir string, desc Descriptor) ([]byte, error) {
+	file, err := securePath(dir, blobName(desc.Digest))
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyFile(file, desc.Digest); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(file)
+}
+
+// CreateImage stores the image config once all its layers are in the store
+// and tags it with the refs.
+func CreateImage(config []byte, refs []string) (*Image, error) {
+	img, err := parseImage(config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, diffID := range img.RootFS.DiffIDs {
+		if _, err := loadLayer(diffID); err != nil {
+			return nil, fmt.Errorf("minidocker: layer %v of image is missing", diffID)
+		}
+	}
+
+	if err := img.storeConfig(config); err != nil {
+		return nil, fmt.Errorf("minidocker: store image failed [%v]", err)
+	}
+
+	for _, ref := range refs {
+		if err := tag(img, ref); err != nil {
+			return nil, fmt.Errorf("minidocker: tag image %v failed [%v]", ref, err)
+		}
+	}
+
+	return img, nil
+}
+
+func parseImage(config []byte) (*Image, error) {
+	var img Image
+	if err := json.Unmarshal(config, &img); err != nil {
+		return nil, fmt.Errorf("minidocker: parse image config failed [%v]", err)
+	}
+
+	if img.RootFS.Type != "layers" {
+		return nil, fmt.Errorf("minidocker: unsupported rootfs type %v", img.RootFS.Type)
+	}
+
+	return &img, nil
+}
+
+// loadLayerFile imports a layer file of the archive, the uncompressed
+// content must match the diff id recorded in the image config.
+func loadLayerFile(file, diffID string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = importLayer(f, diffID)
+	return err
+}
+
+func loadDockerArchive(dir string) ([]*Image, error) {
+	content, err := readArchiveFile(dir, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []archiveManifest
+	if err := json.Unmarshal(content, &manifests); err != nil {
+		return nil, fmt.Errorf("minidocker: parse manifest.json failed [%v]", err)
+	}
+
+	var images []*Image
+	for _, manifest := range manifests {
+		config, err := readArchiveFile(dir, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		// the config is named after its digest in both layouts of docker save
+		name := strings.TrimSuffix(filepath.Base(manifest.Config), ".json")
+		if len(name) == sha256.Size*2 && digestOf(config) != digestPrefix+name {
+			return nil, fmt.Errorf("minidocker: config digest does not match %v", manifest.Config)
+		}
+
+		img, err := parseImage(config)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(manifest.Layers) != len(img.RootFS.DiffIDs) {
+			return nil, fmt.Errorf("minidocker: image has %v layers but %v diff ids", len(manifest.Layers), len(img.RootFS.DiffIDs))
+		}
+
+		for i, layer := range manifest.Layers {
+			file, err := securePath(dir, layer)
+			if err != nil {
+				return nil, err
+			}
+			if err := loadLayerFile(file, img.RootFS.DiffIDs[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		if img, err = CreateImage(config, manifest.RepoTags); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// ociRefs returns the references of a manifest in index.json, a bare tag
+// can not be used without the image name.
+func ociRefs(desc Descriptor) []string {
+	if name := desc.Annotations[AnnotationImageName]; name != "" {
+		return []string{name}
+	}
+
+	if name := desc.Annotations[AnnotationRefName]; strings.ContainsAny(name, ":/") {
+		return []string{name}
+	}
+
+	return nil
+}
+
+func loadOCIManifest(dir string, desc Descriptor, refs []string) ([]*Image, error) {
+	content, err := readBlob(dir, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsIndex(desc.MediaType) {
+		var index Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, fmt.Errorf("minidocker: parse index %v failed [%v]", desc.Digest, err)
+		}
+
+		for _, manifest := range index.Manifests {
+			if MatchPlatform(manifest.Platform) {
+				return loadOCIManifest(dir, manifest, refs)
+			}
+		}
+		return nil, fmt.Errorf("minidocker: no manifest for linux/%v in %v", runtime.GOARCH, desc.Digest)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("minidocker: parse manifest %v failed [%v]", desc.Digest, err)
+	}
+
+	config, err := readBlob(dir, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := parseImage(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifest.Layers) != len(img.RootFS.DiffIDs) {
+		return nil, fmt.Errorf("minidocker: image has %v layers but %v diff ids", len(manifest.Layers), len(img.RootFS.DiffIDs))
+	}
+
+	for i, layer := range manifest.Layers {
+		file, err := securePath(dir, blobName(layer.Digest))
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyFile(file, layer.Digest); err != nil {
+			return nil, err
+		}
+		if err := loadLayerFile(file, img.RootFS.DiffIDs[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	img, err = CreateImage(config, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Image{img}, nil
+}
+
+func loadOCILayout(dir string) ([]*Image, error) {
+	content, err := readArchiveFile(dir, "index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("minidocker: parse index.json failed [%v]", err)
+	}
+
+	var images []*Image
+	for _, desc := range index.Manifests {
+		if !IsIndex(desc.MediaType) && !MatchPlatform(desc.Platform) {
+			continue
+		}
+
+		loaded, err := loadOCIManifest(dir, desc, ociRefs(desc))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, loaded...)
+	}
+
+	return images, nil
+}
+
+// RunImageLoad loads the images of a docker save archive or an OCI image
+// layout tarball, stdin is read when input is empty.
+func RunImageLoad(input string) error {
+	var r io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("minidocker: open %v failed [%v]", input, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	rc, err := archive.DecompressStream(r)
+	if err != nil {
+		return fmt.Errorf("minidocker: read image archive failed [%v]", err)
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(storePath(), 0755); err != nil {
+		return err
+	}
+
+	tmpdir, err := os.MkdirTemp(storePath(), "load-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := extractArchive(rc, tmpdir); err != nil {
+		return err
+	}
+
+	var images []*Image
+	if exist, _ := path.PathExist(filepath.Join(tmpdir, "manifest.json")); exist {
+		images, err = loadDockerArchive(tmpdir)
+	} else if exist, _ := path.PathExist(filepath.Join(tmpdir, "index.json")); exist {
+		images, err = loadOCILayout(tmpdir)
+	} else {
+		err = fmt.Errorf("minidocker: neither manifest.json nor index.json found in image archive")
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		if len(img.RepoTags) == 0 {
+			fmt.Printf("Loaded image ID: sha256:%s\n", img.ID)
+		}
+		for _, repoTag := range img.RepoTags {
+			fmt.Printf("Loaded image: %s\n", repoTag)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTar(t *testing.T, headers []*tar.Header, contents map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		content := contents[hdr.Name]
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestExtractArchiveChainedSymlinks(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "archive")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a -> . and a/b -> .. look harmless one by one, together b points to
+	// the parent of dir
+	archive := buildTar(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: ".", Mode: 0777},
+		{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+		{Name: "b/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b/sub/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: parent, Mode: 0777},
+		{Name: "abs/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"b/pwned": "x", "b/sub/pwned": "x", "abs/pwned": "x"})
+
+	if err := extractArchive(archive, dir); err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	for _, name := range []string{"pwned", "sub/pwned"} {
+		if _, err := os.Lstat(filepath.Join(parent, name)); err == nil {
+			t.Errorf("%v was written outside the archive dir", name)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "pwned")); err != nil {
+		t.Errorf("pwned is not kept inside the archive dir: %v", err)
+	}
+}
+
+func TestSecurePath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink("../../etc", filepath.Join(dir, "up")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(dir, "abs")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"manifest.json":    "manifest.json",
+		"../../etc/passwd": "etc/passwd",
+		"up/passwd":        "etc/passwd",
+		"abs/passwd":       "etc/passwd",
+	}
+	for name, want := range tests {
+		got, err := securePath(dir, name)
+		if err != nil {
+			t.Errorf("securePath(%v) failed: %v", name, err)
+			continue
+		}
+		if got != filepath.Join(dir, want) {
+			t.Errorf("securePath(%v) = %v, want %v", name, got, filepath.Join(dir, want))
+		}
+	}
+}
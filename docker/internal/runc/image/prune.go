@@ -0,0 +1,303 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// References are the images and layers held by containers. The image store
+// does not know about containers, so the caller collects them.
+type References struct {
+	// Containers maps an image ID to the containers created from it.
+	Containers map[string][]string
+	// Layers are the diff ids mounted as lowerdirs by containers.
+	Layers map[string]bool
+}
+
+func (refs *References) usedBy(id string) []string {
+	if refs == nil {
+		return nil
+	}
+
+	return refs.Containers[id]
+}
+
+// Delete removes the image config and the tags of the image, its layers are
+// left to PruneLayers as other images may share them.
+func Delete(id string) error {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for repoTag, imageID := range repositories {
+		if imageID == id {
+			delete(repositories, repoTag)
+			changed = true
+		}
+	}
+	if changed {
+		if err := dumpRepositories(repositories); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(storePath("images", id+".json")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("minidocker: remove image %v failed [%v]", id, err)
+	}
+
+	if err := os.Remove(storePath(parentsName, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// untag removes name:tag from the repositories.
+func untag(repoTag string) error {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+
+	delete(repositories, repoTag)
+	return dumpRepositories(repositories)
+}
+
+// layerRefs counts the images and containers which refer to each layer.
+func layerRefs(refs *References) (map[string]int, error) {
+	images, err := listImages()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, img := range images {
+		for _, digest := range img.RootFS.DiffIDs {
+			counts[strings.TrimPrefix(digest, digestPrefix)]++
+		}
+	}
+	if refs != nil {
+		for digest := range refs.Layers {
+			counts[strings.TrimPrefix(digest, digestPrefix)]++
+		}
+	}
+
+	return counts, nil
+}
+
+// removeLayers removes the layers of digests which no image or container
+// refers to and returns the reclaimed size.
+func removeLayers(digests []string, refs *References) (int64, error) {
+	counts, err := layerRefs(refs)
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64
+	for _, digest := range digests {
+		digest = strings.TrimPrefix(digest, digestPrefix)
+		if counts[digest] != 0 {
+			continue
+		}
+		// a layer listed twice is only removed once
+		counts[digest]++
+
+		if _, err := os.Stat(storePath("layers", digest)); err != nil {
+			continue
+		}
+		if layer, err := loadLayer(digest); err == nil {
+			reclaimed += layer.Size
+		}
+		if err := os.RemoveAll(storePath("layers", digest)); err != nil {
+			return reclaimed, fmt.Errorf("minidocker: remove layer %v failed [%v]", digest, err)
+		}
+		fmt.Printf("Deleted layer: %s%s\n", digestPrefix, digest)
+	}
+
+	return reclaimed, nil
+}
+
+// PruneLayers removes the layers which no image or container refers to and
+// returns the reclaimed size.
+func PruneLayers(refs *References) (int64, error) {
+	files, err := os.ReadDir(storePath("layers"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	digests := make([]string, 0, len(files))
+	for _, file := range files {
+		digests = append(digests, file.Name())
+	}
+
+	return removeLayers(digests, refs)
+}
+
+// children counts the images whose parent is the image.
+func children(images []*Image) map[string]int {
+	counts := map[string]int{}
+	for _, img := range images {
+		if img.Parent != "" {
+			counts[img.Parent]++
+		}
+	}
+
+	return counts
+}
+
+// ChildCounts counts the child images of every image of the store.
+func ChildCounts() (map[string]int, error) {
+	images, err := listImages()
+	if err != nil {
+		return nil, err
+	}
+
+	return children(images), nil
+}
+
+// deleteImage deletes the image followed by its untagged parents which
+// neither have other children nor are used by a container.
+func deleteImage(img *Image, refs *References) error {
+	for img != nil {
+		for _, repoTag := range img.RepoTags {
+			fmt.Printf("Untagged: %s\n", repoTag)
+		}
+		if err := Delete(img.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted: %s%s\n", digestPrefix, img.ID)
+
+		if img.Parent == "" {
+			return nil
+		}
+
+		parent, err := loadImage(img.Parent)
+		if err != nil || len(parent.RepoTags) != 0 || len(refs.usedBy(parent.ID)) != 0 {
+			return nil
+		}
+
+		images, err := listImages()
+		if err != nil {
+			return err
+		}
+		if children(images)[parent.ID] != 0 {
+			return nil
+		}
+		img = parent
+	}
+
+	return nil
+}
+
+// RemoveImage removes a tag of the image, the image itself is deleted with
+// its last tag or when it is referred to by ID. An image used by a
+// container is only deleted with force.
+func RemoveImage(ref string, force bool, refs *References) error {
+	img, err := Lookup(ref)
+	if err != nil {
+		return err
+	}
+
+	if repoTag := lookupTag(ref); repoTag != "" && len(img.RepoTags) > 1 {
+		if err := untag(repoTag); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", repoTag)
+		return nil
+	}
+
+	if lookupTag(ref) == "" && len(img.RepoTags) > 1 && !force {
+		return fmt.Errorf("minidocker: image %v is referenced by %v, remove the tags or use -f", img.ID[:12], strings.Join(img.RepoTags, ", "))
+	}
+
+	if containers := refs.usedBy(img.ID); len(containers) != 0 && !force {
+		return fmt.Errorf("minidocker: image %v is used by container %v, remove the container or use -f", ref, strings.Join(containers, ", "))
+	}
+
+	images, err := listImages()
+	if err != nil {
+		return err
+	}
+	if children(images)[img.ID] != 0 && !force {
+		return fmt.Errorf("minidocker: image %v has dependent child images", ref)
+	}
+
+	return deleteImage(img, refs)
+}
+
+// RunImageRemove removes the images, followed by their layers which are no
+// longer referred to. The layers of the parents are part of the layers of an
+// image, so they are covered when the parents are deleted along.
+func RunImageRemove(refs []string, force bool, references *References) error {
+	var failed, layers []string
+	for _, ref := range refs {
+		img, err := Lookup(ref)
+		if err == nil {
+			err = RemoveImage(ref, force, references)
+		}
+		if err != nil {
+			log.Error(err)
+			failed = append(failed, ref)
+			continue
+		}
+		layers = append(layers, img.RootFS.DiffIDs...)
+	}
+
+	if _, err := removeLayers(layers, references); err != nil {
+		return fmt.Errorf("minidocker: remove layers failed [%v]", err)
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("minidocker: remove images %v failed", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// RunImagePrune deletes the dangling images, which are untagged, have no
+// child images and are not used by a container, then the unreferenced
+// layers.
+func RunImagePrune(refs *References) error {
+	for {
+		images, err := listImages()
+		if err != nil {
+			return err
+		}
+		counts := children(images)
+
+		var dangling []*Image
+		for _, img := range images {
+			if len(img.RepoTags) == 0 && counts[img.ID] == 0 && len(refs.usedBy(img.ID)) == 0 {
+				dangling = append(dangling, img)
+			}
+		}
+		if len(dangling) == 0 {
+			break
+		}
+
+		sort.Slice(dangling, func(i, j int) bool { return dangling[i].ID < dangling[j].ID })
+		for _, img := range dangling {
+			if err := Delete(img.ID); err != nil {
+				return err
+			}
+			fmt.Printf("Deleted: %s%s\n", digestPrefix, img.ID)
+		}
+	}
+
+	reclaimed, err := PruneLayers(refs)
+	if err != nil {
+		return fmt.Errorf("minidocker: prune layers failed [%v]", err)
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", HumanSize(reclaimed))
+	return nil
+}
@@ -0,0 +1,81 @@
+package image
+
+import (
+	"archive/tar"
+	"docker/internal/utils/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// importImage stores a single layer image whose file holds content.
+func importImage(t *testing.T, ref, content string) *Image {
+	t.Helper()
+
+	archive := buildTar(t, []*tar.Header{{Name: "file", Typeflag: tar.TypeReg, Mode: 0644}}, map[string]string{"file": content})
+	file := filepath.Join(t.TempDir(), "rootfs.tar")
+	if err := os.WriteFile(file, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Import(file, ref)
+	if err != nil {
+		t.Fatalf("import %v failed: %v", ref, err)
+	}
+
+	return img
+}
+
+func layerExists(img *Image) bool {
+	_, err := os.Stat(LayerDiffPath(img.RootFS.DiffIDs[0]))
+	return err == nil
+}
+
+func TestRunImageRemoveKeepsOtherLayers(t *testing.T) {
+	config.SetDataRoot(t.TempDir())
+
+	removed := importImage(t, "removed:1", "removed")
+	kept := importImage(t, "kept:1", "kept")
+	shared := importImage(t, "shared:1", "removed")
+	// the layer of an image deleted without rmi is left to prune
+	orphan := importImage(t, "orphan:1", "orphan")
+	if err := Delete(orphan.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RunImageRemove([]string{"removed:1"}, false, &References{}); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if !layerExists(shared) {
+		t.Fatal("the layer shared with another image was removed")
+	}
+	if !layerExists(kept) || !layerExists(orphan) {
+		t.Fatal("a layer of another image was removed")
+	}
+
+	if err := RunImageRemove([]string{"shared:1"}, false, &References{}); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if layerExists(removed) {
+		t.Fatal("the layer of the removed images is kept")
+	}
+	if !layerExists(orphan) {
+		t.Fatal("the unreferenced layer of another image was removed")
+	}
+
+	// a container still mounts the layer
+	refs := &References{Layers: map[string]bool{kept.RootFS.DiffIDs[0]: true}}
+	if err := RunImageRemove([]string{"kept:1"}, true, refs); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if !layerExists(kept) {
+		t.Fatal("the layer used by a container was removed")
+	}
+
+	if _, err := PruneLayers(nil); err != nil {
+		t.Fatal(err)
+	}
+	if layerExists(orphan) || layerExists(kept) {
+		t.Fatal("prune kept unreferenced layers")
+	}
+}
@@ -0,0 +1,236 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// archiveWriter writes every blob once into the archive.
+type archiveWriter struct {
+	tw      *tar.Writer
+	written map[string]bool
+}
+
+func (aw *archiveWriter) writeHeader(name string, size int64) error {
+	return aw.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+		Typeflag: tar.TypeReg,
+	})
+}
+
+func (aw *archiveWriter) writeFile(name string, content []byte) error {
+	if err := aw.writeHeader(name, int64(len(content))); err != nil {
+		return err
+	}
+
+	_, err := aw.tw.Write(content)
+	return err
+}
+
+func (aw *archiveWriter) writeBlob(digest string, content []byte) error {
+	if aw.written[digest] {
+		return nil
+	}
+	aw.written[digest] = true
+
+	return aw.writeFile(blobName(digest), content)
+}
+
+// writeLayer streams the layer tarball of the store into the archive.
+func (aw *archiveWriter) writeLayer(digest string) (int64, error) {
+	file, err := os.Open(layerPath(digest, layerTarName))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if aw.written[digest] {
+		return info.Size(), nil
+	}
+	aw.written[digest] = true
+
+	if err := aw.writeHeader(blobName(digest), info.Size()); err != nil {
+		return 0, err
+	}
+
+	if _, err := io.Copy(aw.tw, file); err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (aw *archiveWriter) writeDirs() error {
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := aw.tw.WriteHeader(&tar.Header{
+			Name:     dir,
+			Mode:     0755,
+			ModTime:  time.Unix(0, 0),
+			Typeflag: tar.TypeDir,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RawConfig returns the stored image config, its sha256 is the image ID.
+func (img *Image) RawConfig() ([]byte, error) {
+	return os.ReadFile(storePath("images", img.ID+".json"))
+}
+
+// LayerTarPath is the uncompressed layer tarball kept in the store.
+func LayerTarPath(digest string) string {
+	return layerPath(digest, layerTarName)
+}
+
+// OCIManifest builds the manifest of the image with uncompressed layers.
+func (img *Image) OCIManifest() (*Manifest, error) {
+	config, err := img.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config: Descriptor{
+			MediaType: MediaTypeOCIConfig,
+			Digest:    digestPrefix + img.ID,
+			Size:      int64(len(config)),
+		},
+	}
+
+	for _, diffID := range img.RootFS.DiffIDs {
+		info, err := os.Stat(LayerTarPath(diffID))
+		if err != nil {
+			return nil, err
+		}
+		manifest.Layers = append(manifest.Layers, Descriptor{
+			MediaType: MediaTypeOCILayer,
+			Digest:    diffID,
+			Size:      info.Size(),
+		})
+	}
+
+	return manifest, nil
+}
+
+// saveImage writes the blobs of the image and returns its entries of
+// manifest.json and index.json.
+func (aw *archiveWriter) saveImage(ref string) (*archiveManifest, *Descriptor, error) {
+	img, err := Lookup(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := img.RawConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := aw.writeBlob(digestPrefix+img.ID, config); err != nil {
+		return nil, nil, err
+	}
+
+	manifest, err := img.OCIManifest()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry := &archiveManifest{Config: blobName(digestPrefix + img.ID)}
+	for _, layer := range manifest.Layers {
+		if _, err := aw.writeLayer(layer.Digest); err != nil {
+			return nil, nil, err
+		}
+		entry.Layers = append(entry.Layers, blobName(layer.Digest))
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := aw.writeBlob(digestOf(content), content); err != nil {
+		return nil, nil, err
+	}
+
+	desc := &Descriptor{
+		MediaType: MediaTypeOCIManifest,
+		Digest:    digestOf(content),
+		Size:      int64(len(content)),
+	}
+
+	if repoTag := lookupTag(ref); repoTag != "" {
+		_, tag, _ := ParseReference(repoTag)
+		entry.RepoTags = []string{repoTag}
+		desc.Annotations = map[string]string{
+			AnnotationImageName: repoTag,
+			AnnotationRefName:   tag,
+		}
+	}
+
+	return entry, desc, nil
+}
+
+func writeJSON(aw *archiveWriter, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return aw.writeFile(name, content)
+}
+
+// RunImageSave writes the images into a tarball which is both a docker save
+// archive and an OCI image layout, stdout is written when output is empty.
+func RunImageSave(output string, refs []string) error {
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("minidocker: create %v failed [%v]", output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	aw := &archiveWriter{tw: tar.NewWriter(w), written: map[string]bool{}}
+	if err := aw.writeDirs(); err != nil {
+		return err
+	}
+
+	var manifests []*archiveManifest
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	for _, ref := range refs {
+		entry, desc, err := aw.saveImage(ref)
+		if err != nil {
+			return fmt.Errorf("minidocker: save image %v failed [%v]", ref, err)
+		}
+		manifests = append(manifests, entry)
+		index.Manifests = append(index.Manifests, *desc)
+	}
+
+	if err := writeJSON(aw, "oci-layout", map[string]string{"imageLayoutVersion": "1.0.0"}); err != nil {
+		return err
+	}
+	if err := writeJSON(aw, "index.json", index); err != nil {
+		return err
+	}
+	if err := writeJSON(aw, "manifest.json", manifests); err != nil {
+		return err
+	}
+
+	return aw.tw.Close()
+}
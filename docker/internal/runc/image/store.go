@@ -0,0 +1,437 @@
+package image
+
+import (
+	"crypto/sha256"
+	"docker/internal/utils/cmdtable"
+	"docker/internal/utils/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gosuri/uitable"
+)
+
+const (
+	defaultTag       = "latest"
+	repositoriesName = "repositories.json"
+	parentsName      = "parents"
+)
+
+// shortIDPattern is a prefix of an image id which Lookup accepts.
+var shortIDPattern = regexp.MustCompile(`^[a-f0-9]{6,64}$`)
+
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// Image is the image config in the OCI layout, the ID is the sha256 of the
+// stored config. RepoTags are not part of the config and are filled from
+// the repositories on load. Parent is the image a commit or a build step
+// started from, it is kept next to the config.
+type Image struct {
+	ID           string    `json:"-"`
+	RepoTags     []string  `json:"-"`
+	Parent       string    `json:"-"`
+	Created      string    `json:"created"`
+	Author       string    `json:"author,omitempty"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       Config    `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+// storePath joins elem under the image store in the data root.
+func storePath(elem ...string) string {
+	return config.DataPath(append([]string{"image"}, elem...)...)
+}
+
+// ParseReference normalizes an image reference into name:tag, the tag
+// defaults to latest. A port in the registry host is not taken as tag.
+func ParseReference(ref string) (string, string, error) {
+	if ref == "" {
+		return "", "", fmt.Errorf("minidocker: empty image reference")
+	}
+
+	name, tag := ref, defaultTag
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+
+	if name == "" || tag == "" {
+		return "", "", fmt.Errorf("minidocker: invalid image reference %v", ref)
+	}
+
+	return name, tag, nil
+}
+
+func loadRepositories() (map[string]string, error) {
+	repositories := map[string]string{}
+
+	content, err := os.ReadFile(storePath(repositoriesName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &repositories); err != nil {
+		return nil, fmt.Errorf("minidocker: parse image repositories failed [%v]", err)
+	}
+
+	return repositories, nil
+}
+
+func dumpRepositories(repositories map[string]string) error {
+	content, err := json.MarshalIndent(repositories, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(storePath(repositoriesName), content, 0644)
+}
+
+// LowerDirs returns the overlay lowerdirs of the image, the top layer first.
+func (img *Image) LowerDirs() []string {
+	var dirs []string
+	for i := len(img.RootFS.DiffIDs) - 1; i >= 0; i-- {
+		dirs = append(dirs, LayerDiffPath(img.RootFS.DiffIDs[i]))
+	}
+
+	return dirs
+}
+
+// Size is the sum of the image layers.
+func (img *Image) Size() int64 {
+	var size int64
+	for _, digest := range img.RootFS.DiffIDs {
+		if layer, err := loadLayer(digest); err == nil {
+			size += layer.Size
+		}
+	}
+
+	return size
+}
+
+// store writes the image config into the store and sets the image ID.
+func (img *Image) store() error {
+	content, err := json.MarshalIndent(img, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := img.storeConfig(content); err != nil {
+		return err
+	}
+
+	if img.Parent == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(storePath(parentsName), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(storePath(parentsName, img.ID), []byte(img.Parent), 0644)
+}
+
+func (img *Image) storeConfig(content []byte) error {
+	sum := sha256.Sum256(content)
+	img.ID = hex.EncodeToString(sum[:])
+
+	if err := os.MkdirAll(storePath("images"), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(storePath("images", img.ID+".json"), content, 0644)
+}
+
+func loadImage(id string) (*Image, error) {
+	content, err := os.ReadFile(storePath("images", id+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return nil, fmt.Errorf("minidocker: parse image %v failed [%v]", id, err)
+	}
+	img.ID = id
+
+	if parent, err := os.ReadFile(storePath(parentsName, id)); err == nil {
+		img.Parent = string(parent)
+	}
+
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	for repoTag, imageID := range repositories {
+		if imageID == id {
+			img.RepoTags = append(img.RepoTags, repoTag)
+		}
+	}
+	sort.Strings(img.RepoTags)
+
+	return &img, nil
+}
+
+func listImages() ([]*Image, error) {
+	files, err := os.ReadDir(storePath("images"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var images []*Image
+	for _, file := range files {
+		img, err := loadImage(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			log.Errorf("load image %v failed: %v", file.Name(), err)
+			continue
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// lookupTag returns the normalized name:tag if ref is a tag in the store.
+func lookupTag(ref string) string {
+	name, tag, err := ParseReference(ref)
+	if err != nil {
+		return ""
+	}
+
+	repositories, err := loadRepositories()
+	if err != nil {
+		return ""
+	}
+
+	if _, exist := repositories[name+":"+tag]; exist {
+		return name + ":" + tag
+	}
+
+	return ""
+}
+
+// Lookup finds an image by name:tag or by a prefix of its ID.
+func Lookup(ref string) (*Image, error) {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	if name, tag, err := ParseReference(ref); err == nil {
+		if id, exist := repositories[name+":"+tag]; exist {
+			return loadImage(id)
+		}
+	}
+
+	// a short id needs enough hex digits to be meaningful, as in docker
+	prefix := strings.TrimPrefix(ref, digestPrefix)
+	if !shortIDPattern.MatchString(prefix) {
+		return nil, fmt.Errorf("minidocker: image %v not found", ref)
+	}
+
+	images, err := listImages()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Image
+	for _, img := range images {
+		if strings.HasPrefix(img.ID, prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("minidocker: image id %v is ambiguous", ref)
+			}
+			found = img
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("minidocker: image %v not found", ref)
+	}
+
+	return found, nil
+}
+
+// tag points name:tag to the image, the tag is moved off any image which
+// held it before.
+func tag(img *Image, ref string) error {
+	name, tag, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	repoTag := name + ":" + tag
+
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+
+	repositories[repoTag] = img.ID
+	if err := dumpRepositories(repositories); err != nil {
+		return err
+	}
+
+	img.RepoTags = append(removeString(img.RepoTags, repoTag), repoTag)
+	return nil
+}
+
+// Tag points name:tag to the image.
+func Tag(img *Image, ref string) error {
+	if err := tag(img, ref); err != nil {
+		return fmt.Errorf("minidocker: tag image %v failed [%v]", ref, err)
+	}
+
+	return nil
+}
+
+func removeString(list []string, s string) []string {
+	var result []string
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// Import stores a rootfs tarball, such as the output of export, as a single
+// layer image tagged as ref. The tarball is read from stdin for "-".
+func Import(imageTar, ref string) (*Image, error) {
+	if _, _, err := ParseReference(ref); err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = os.Stdin
+	if imageTar != "-" {
+		file, err := os.Open(imageTar)
+		if err != nil {
+			return nil, fmt.Errorf("minidocker: open image tar %v failed [%v]", imageTar, err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	layer, err := importLayer(r, "")
+	if err != nil {
+		return nil, err
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	img := &Image{
+		Created:      created,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		RootFS: RootFS{
+			Type:    "layers",
+			DiffIDs: []string{layer.Digest},
+		},
+		History: []History{{
+			Created: created,
+			Comment: "Imported from " + filepath.Base(imageTar),
+		}},
+	}
+	if err := img.store(); err != nil {
+		return nil, fmt.Errorf("minidocker: store image failed [%v]", err)
+	}
+
+	if err := tag(img, ref); err != nil {
+		return nil, fmt.Errorf("minidocker: tag image %v failed [%v]", ref, err)
+	}
+
+	return img, nil
+}
+
+func RunImageImport(imageTar, ref string) error {
+	img, err := Import(imageTar, ref)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(img.ID)
+	return nil
+}
+
+// HumanSize formats a size in bytes with decimal units such as 3.73MB.
+func HumanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value, unit := float64(size), 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+
+	return fmt.Sprintf("%.3g%s", value, units[unit])
+}
+
+// RunImageList lists the images, untagged intermediate images which are the
+// parent of another image are only listed with all.
+func RunImageList(all bool) error {
+	images, err := listImages()
+	if err != nil {
+		return fmt.Errorf("minidocker: list images failed [%v]", err)
+	}
+
+	parents := map[string]bool{}
+	for _, img := range images {
+		parents[img.Parent] = true
+	}
+
+	type row struct {
+		repository, tag string
+		img             *Image
+	}
+
+	var rows []row
+	for _, img := range images {
+		if len(img.RepoTags) == 0 && parents[img.ID] && !all {
+			continue
+		}
+		if len(img.RepoTags) == 0 {
+			rows = append(rows, row{"<none>", "<none>", img})
+		}
+		for _, repoTag := range img.RepoTags {
+			name, tag, _ := ParseReference(repoTag)
+			rows = append(rows, row{name, tag, img})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].repository != rows[j].repository {
+			return rows[i].repository < rows[j].repository
+		}
+		return rows[i].tag < rows[j].tag
+	})
+
+	table := uitable.New()
+	table.AddRow("REPOSITORY", "TAG", "IMAGE ID", "CREATED", "SIZE")
+	for _, r := range rows {
+		table.AddRow(r.repository, r.tag, r.img.ID[:12], r.img.Created, HumanSize(r.img.Size()))
+	}
+
+	return cmdtable.EncodeTable(os.Stdout, table)
+}
@@ -0,0 +1,29 @@
+package image
+
+import (
+	"docker/internal/utils/config"
+	"testing"
+)
+
+func TestLookupIDPrefix(t *testing.T) {
+	config.SetDataRoot(t.TempDir())
+	img := importImage(t, "app:1", "app")
+
+	for _, ref := range []string{"app:1", img.ID, img.ID[:6], digestPrefix + img.ID, digestPrefix + img.ID[:12]} {
+		found, err := Lookup(ref)
+		if err != nil {
+			t.Errorf("Lookup(%q) failed: %v", ref, err)
+			continue
+		}
+		if found.ID != img.ID {
+			t.Errorf("Lookup(%q) = %v, want %v", ref, found.ID, img.ID)
+		}
+	}
+
+	// the only image of the store must not match a short or empty prefix
+	for _, ref := range []string{"", digestPrefix, img.ID[:5], digestPrefix + img.ID[:5], img.ID + "0"} {
+		if found, err := Lookup(ref); err == nil {
+			t.Errorf("Lookup(%q) = %v, want no image", ref, found.ID)
+		}
+	}
+}
@@ -11,16 +11,12 @@ import (
 	"strings"
 )
 
-const ipamDefaultAllocatePath = "/var/run/minidocker/network/ipam/subnet.json"
-
 type IPAM struct {
 	SubnetAllocatePath string
 	Subnets            map[string]string
 }
 
-var ipAllocator = &IPAM{
-	SubnetAllocatePath: ipamDefaultAllocatePath,
-}
+var ipAllocator = &IPAM{}
 
 func (ipam *IPAM) load() error {
 	exist, err := upath.PathExist(ipam.SubnetAllocatePath)
@@ -2,6 +2,7 @@ package network
 
 import (
 	"docker/internal/utils/cmdtable"
+	"docker/internal/utils/config"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -15,7 +16,10 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
-const defaultNetworkPath = "/var/run/minidocker/network/network/"
+// networkDir is the runtime directory holding the network configs.
+func networkDir() string {
+	return config.RootPath("network", "network")
+}
 
 type Network struct {
 	Name    string
@@ -26,7 +30,7 @@ type Network struct {
 func ListNetwork() error {
 	var networks = map[string]*Network{}
 
-	filepath.Walk(defaultNetworkPath, func(networkPath string, info os.FileInfo, err error) error {
+	filepath.Walk(networkDir(), func(networkPath string, info os.FileInfo, err error) error {
 		if info.IsDir() {
 			return nil
 		}
@@ -63,8 +67,7 @@ func DeleteNetwork(name string) error {
 		return fmt.Errorf("delete interface failed: %v", err)
 	}
 
-	networkPath := path.Join(defaultNetworkPath, name)
-	return os.Remove(networkPath)
+	return os.Remove(path.Join(networkDir(), name))
 }
 
 func CreateNetwork(subnet, driver, name string) error {
@@ -82,7 +85,7 @@ func CreateNetwork(subnet, driver, name string) error {
 		return fmt.Errorf("failed to create network: %v", err)
 	}
 
-	return network.dump(defaultNetworkPath)
+	return network.dump(networkDir())
 }
 
 func setPortMapping(endpoint *Endpoint, portMapping string) error {
@@ -106,9 +109,8 @@ func setPortMapping(endpoint *Endpoint, portMapping string) error {
 }
 
 func ConnectNetwork(containerName, networkName, portMapping, pid string) error {
-	networkPath := path.Join(defaultNetworkPath, networkName)
 	network := &Network{Name: networkName}
-	if err := network.load(networkPath); err != nil {
+	if err := network.load(path.Join(networkDir(), networkName)); err != nil {
 		return err
 	}
 
@@ -165,9 +167,11 @@ func Init() error {
 		"bridge": bridge,
 	}
 
-	if _, err := os.Stat(defaultNetworkPath); err != nil {
+	ipAllocator.SubnetAllocatePath = config.RootPath("network", "ipam", "subnet.json")
+
+	if _, err := os.Stat(networkDir()); err != nil {
 		if os.IsNotExist(err) {
-			os.MkdirAll(defaultNetworkPath, 0644)
+			os.MkdirAll(networkDir(), 0644)
 		} else {
 			return err
 		}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// challenge is a parsed WWW-Authenticate header, such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+type challenge struct {
+	scheme     string
+	parameters map[string]string
+}
+
+func parseChallenge(header string) *challenge {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	c := &challenge{scheme: strings.ToLower(scheme), parameters: map[string]string{}}
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			c.parameters[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+
+	return c
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// fetchToken asks the token server of a bearer challenge for a token of the
+// scope, the stored credentials are sent if there are any.
+func (c *Client) fetchToken(ch *challenge, scope string) (string, error) {
+	realm, err := url.Parse(ch.parameters["realm"])
+	if err != nil || ch.parameters["realm"] == "" {
+		return "", fmt.Errorf("minidocker: invalid token realm %q", ch.parameters["realm"])
+	}
+
+	query := realm.Query()
+	if service := ch.parameters["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("minidocker: request token failed [%v]", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("minidocker: request token failed [%v]", resp.Status)
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("minidocker: parse token failed [%v]", err)
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("minidocker: empty token from %v", realm.Host)
+	}
+
+	return token.Token, nil
+}
+
+// authorize answers the challenge of a 401 response, it tells whether the
+// request is worth a retry.
+func (c *Client) authorize(resp *http.Response, scope string) (bool, error) {
+	ch := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+
+	switch ch.scheme {
+	case "bearer":
+		token, err := c.fetchToken(ch, scope)
+		if err != nil {
+			return false, err
+		}
+		c.tokens[scope] = "Bearer " + token
+		return true, nil
+	case "basic":
+		if c.username == "" {
+			return false, nil
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(c.username, c.password)
+		c.tokens[scope] = req.Header.Get("Authorization")
+		return true, nil
+	}
+
+	return false, nil
+}
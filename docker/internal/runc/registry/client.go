@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"docker/internal/utils/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to a registry over the OCI distribution (registry v2) API.
+type Client struct {
+	base     *url.URL
+	client   *http.Client
+	username string
+	password string
+	// tokens caches the Authorization header per scope
+	tokens map[string]string
+}
+
+func NewClient(host string) *Client {
+	scheme := "https"
+	if config.IsInsecureRegistry(host) {
+		scheme = "http"
+	}
+
+	username, password := config.Credentials(host)
+	if username == "" && host == defaultRegistry {
+		username, password = config.Credentials(defaultDomain)
+	}
+
+	return &Client{
+		base:     &url.URL{Scheme: scheme, Host: host},
+		client:   &http.Client{},
+		username: username,
+		password: password,
+		tokens:   map[string]string{},
+	}
+}
+
+func pullScope(repository string) string {
+	return "repository:" + repository + ":pull"
+}
+
+func pushScope(repository string) string {
+	return "repository:" + repository + ":pull,push"
+}
+
+// resolve turns a path or a Location header into an absolute URL.
+func (c *Client) resolve(location string) (*url.URL, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.base.ResolveReference(u), nil
+}
+
+// do sends the request with the authorization of the scope, a 401 response
+// is answered once. newBody recreates the body for the retry.
+func (c *Client) do(method, location, scope string, header http.Header, newBody func() (io.Reader, int64)) (*http.Response, error) {
+	u, err := c.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+
+	for retry := 0; ; retry++ {
+		var body io.Reader
+		var length int64
+		if newBody != nil {
+			body, length = newBody()
+		}
+
+		req, err := http.NewRequest(method, u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		if newBody != nil {
+			req.ContentLength = length
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if auth := c.tokens[scope]; auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("minidocker: %v %v failed [%v]", method, u, err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized || retry > 0 {
+			return resp, nil
+		}
+
+		retryable, err := c.authorize(resp, scope)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !retryable {
+			return nil, fmt.Errorf("minidocker: %v %v unauthorized", method, u)
+		}
+	}
+}
+
+// statusError reads the registry error of an unexpected response.
+func statusError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("minidocker: %v %v returned %v: %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(content)))
+}
+
+// Ping checks the registry is reachable with the credentials of the client.
+func (c *Client) Ping() error {
+	resp, err := c.do(http.MethodGet, "/v2/", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"docker/internal/runc/image"
+	"docker/internal/utils/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "test-token"
+
+// fakeRegistry is an in-memory registry v2 which asks for a bearer token
+// issued by its own /token endpoint.
+type fakeRegistry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	types     map[string]string
+	uploads   map[string]*bytes.Buffer
+	scopes    []string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+
+	r := &fakeRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+		uploads:   map[string]*bytes.Buffer{},
+	}
+	r.server = httptest.NewServer(r)
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		if req.URL.Query().Get("service") != "fake" {
+			http.Error(w, "unknown service", http.StatusBadRequest)
+			return
+		}
+		r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+		fmt.Fprintf(w, `{"token":%q}`, testToken)
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.server.URL))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := req.URL.Path
+	switch {
+	case path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/blobs/uploads/"):
+		r.serveUpload(w, req)
+	case strings.Contains(path, "/blobs/"):
+		digest := path[strings.LastIndex(path, "/")+1:]
+		content, ok := r.blobs[digest]
+		if !ok {
+			http.Error(w, "blob unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		if req.Method == http.MethodGet {
+			w.Write(content)
+		}
+	case strings.Contains(path, "/manifests/"):
+		r.serveManifest(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch req.Method {
+	case http.MethodPost:
+		id := fmt.Sprint(len(r.uploads))
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", path+id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		upload := r.uploads[path[strings.LastIndex(path, "/")+1:]]
+		if upload == nil {
+			http.Error(w, "upload unknown", http.StatusNotFound)
+			return
+		}
+		io.Copy(upload, req.Body)
+		w.Header().Set("Location", path)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		upload := r.uploads[path[strings.LastIndex(path, "/")+1:]]
+		if upload == nil {
+			http.Error(w, "upload unknown", http.StatusNotFound)
+			return
+		}
+		io.Copy(upload, req.Body)
+		digest := req.URL.Query().Get("digest")
+		if digestOf(upload.Bytes()) != digest {
+			http.Error(w, "digest invalid", http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = upload.Bytes()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	i := strings.Index(path, "/manifests/")
+	repository, object := strings.TrimPrefix(path[:i], "/v2/"), path[i+len("/manifests/"):]
+
+	switch req.Method {
+	case http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		digest := digestOf(content)
+		for _, key := range []string{repository + ":" + object, repository + "@" + digest} {
+			r.manifests[key] = content
+			r.types[key] = req.Header.Get("Content-Type")
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		key := repository + ":" + object
+		if strings.HasPrefix(object, "sha256:") {
+			key = repository + "@" + object
+		}
+		content, ok := r.manifests[key]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[key])
+		w.Write(content)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+// importTestImage stores a single layer image with one file as ref.
+func importTestImage(t *testing.T, ref string) *image.Image {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("hello registry\n")
+	tw.WriteHeader(&tar.Header{Name: "hello", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+
+	file := filepath.Join(t.TempDir(), "rootfs.tar")
+	if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := image.Import(file, ref)
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	return img
+}
+
+func TestBearerChallenge(t *testing.T) {
+	r := newFakeRegistry(t)
+
+	c := NewClient(r.host())
+	if err := c.Ping(); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+
+	// the token is cached per scope
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := c.getManifest("app", "latest", pullScope("app")); err == nil {
+			t.Fatal("missing manifest was found")
+		}
+	}
+
+	want := []string{"", "repository:app:pull"}
+	if strings.Join(r.scopes, ",") != strings.Join(want, ",") {
+		t.Fatalf("token scopes = %q, want %q", r.scopes, want)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	ch := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:app:pull,push"`)
+	if ch.scheme != "bearer" {
+		t.Fatalf("scheme = %v, want bearer", ch.scheme)
+	}
+
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:app:pull,push",
+	}
+	for key, value := range want {
+		if ch.parameters[key] != value {
+			t.Errorf("%v = %q, want %q", key, ch.parameters[key], value)
+		}
+	}
+}
+
+func TestPushPullRoundTrip(t *testing.T) {
+	r := newFakeRegistry(t)
+	ref := r.host() + "/team/app:v1"
+
+	config.SetDataRoot(t.TempDir())
+	pushed := importTestImage(t, ref)
+	if err := Push(ref); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	if len(r.blobs) != 2 {
+		t.Fatalf("registry has %v blobs, want the layer and the config", len(r.blobs))
+	}
+	if _, ok := r.manifests["team/app:v1"]; !ok {
+		t.Fatal("the manifest was not pushed")
+	}
+
+	// pull into an empty store
+	config.SetDataRoot(t.TempDir())
+	pulled, err := Pull(ref)
+	if err != nil {
+		t.Fatalf("pull failed: %v", err)
+	}
+	if pulled.ID != pushed.ID {
+		t.Fatalf("pulled image %v, want %v", pulled.ID, pushed.ID)
+	}
+
+	img, err := image.Lookup(ref)
+	if err != nil {
+		t.Fatalf("the pulled image is not tagged: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(image.LayerDiffPath(img.RootFS.DiffIDs[0]), "hello"))
+	if err != nil || string(content) != "hello registry\n" {
+		t.Fatalf("pulled layer content = %q, %v", content, err)
+	}
+
+	// a corrupted blob is refused
+	for digest := range r.blobs {
+		r.blobs[digest] = append(r.blobs[digest], 0)
+	}
+	config.SetDataRoot(t.TempDir())
+	if _, err := Pull(ref); err == nil {
+		t.Fatal("pull of corrupted blobs succeeded")
+	}
+}
@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"docker/internal/runc/image"
+	"docker/internal/utils/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// maxManifestSize bounds the manifests read into memory.
+const maxManifestSize = 4 << 20
+
+var manifestMediaTypes = []string{
+	image.MediaTypeOCIManifest,
+	image.MediaTypeOCIIndex,
+	image.MediaTypeDockerManifest,
+	image.MediaTypeDockerManifestList,
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+
+	return digest
+}
+
+// getManifest fetches the manifest or index of a tag or digest, a manifest
+// asked by digest is verified against it.
+func (c *Client) getManifest(repository, object, scope string) ([]byte, string, string, error) {
+	header := http.Header{"Accept": {strings.Join(manifestMediaTypes, ", ")}}
+	resp, err := c.do(http.MethodGet, "/v2/"+repository+"/manifests/"+object, scope, header, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", statusError(resp)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("minidocker: read manifest %v failed [%v]", object, err)
+	}
+
+	digest := digestOf(content)
+	if strings.HasPrefix(object, "sha256:") && digest != object {
+		return nil, "", "", fmt.Errorf("minidocker: manifest digest %v does not match %v", digest, object)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var versioned struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(content, &versioned) == nil && versioned.MediaType != "" {
+		mediaType = versioned.MediaType
+	}
+
+	return content, mediaType, digest, nil
+}
+
+// getBlob opens the blob stream, redirects to a storage backend are
+// followed by the http client.
+func (c *Client) getBlob(repository, digest, scope string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, "/v2/"+repository+"/blobs/"+digest, scope, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	return resp.Body, nil
+}
+
+func (c *Client) readBlob(repository, digest, scope string) ([]byte, error) {
+	body, err := c.getBlob(repository, digest, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(body, maxManifestSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if actual := digestOf(content); actual != digest {
+		return nil, fmt.Errorf("minidocker: blob digest %v does not match %v", actual, digest)
+	}
+
+	return content, nil
+}
+
+// pullLayer downloads the layer blob into a temp file, verifies its digest
+// and imports it, the uncompressed content is verified against the diff id.
+func (c *Client) pullLayer(repository string, layer image.Descriptor, diffID, scope string) error {
+	body, err := c.getBlob(repository, layer.Digest, scope)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if err := os.MkdirAll(config.DataPath("image"), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(config.DataPath("image"), "pull-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, h), body); err != nil {
+		return fmt.Errorf("minidocker: download layer %v failed [%v]", layer.Digest, err)
+	}
+
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != layer.Digest {
+		return fmt.Errorf("minidocker: layer digest %v does not match %v", actual, layer.Digest)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return image.ImportLayer(file, diffID)
+}
+
+// resolveManifest fetches the manifest of the reference, a manifest list is
+// resolved to the manifest of linux on this architecture.
+func (c *Client) resolveManifest(ref *Reference, scope string) (*image.Manifest, string, error) {
+	content, mediaType, digest, err := c.getManifest(ref.Repository, ref.Object(), scope)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if image.IsIndex(mediaType) {
+		var index image.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, "", fmt.Errorf("minidocker: parse manifest list failed [%v]", err)
+		}
+
+		found := false
+		for _, desc := range index.Manifests {
+			if desc.Platform != nil && image.MatchPlatform(desc.Platform) {
+				content, mediaType, _, err = c.getManifest(ref.Repository, desc.Digest, scope)
+				if err != nil {
+					return nil, "", err
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, "", fmt.Errorf("minidocker: no manifest for linux/%v in %v", runtime.GOARCH, ref)
+		}
+	}
+
+	if mediaType != image.MediaTypeOCIManifest && mediaType != image.MediaTypeDockerManifest {
+		return nil, "", fmt.Errorf("minidocker: unsupported manifest type %v", mediaType)
+	}
+
+	var manifest image.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, "", fmt.Errorf("minidocker: parse manifest failed [%v]", err)
+	}
+
+	return &manifest, digest, nil
+}
+
+// Pull fetches the image from its registry into the local image store.
+func Pull(name string) (*image.Image, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewClient(ref.Host())
+	scope := pullScope(ref.Repository)
+
+	manifest, digest, err := c.resolveManifest(ref, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := c.readBlob(ref.Repository, manifest.Config.Digest, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		RootFS image.RootFS `json:"rootfs"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("minidocker: parse image config failed [%v]", err)
+	}
+
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("minidocker: image has %v layers but %v diff ids", len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	for i, layer := range manifest.Layers {
+		diffID := config.RootFS.DiffIDs[i]
+		if image.HasLayer(diffID) {
+			fmt.Printf("%s: Already exists\n", shortDigest(layer.Digest))
+			continue
+		}
+
+		fmt.Printf("%s: Pulling fs layer\n", shortDigest(layer.Digest))
+		if err := c.pullLayer(ref.Repository, layer, diffID, scope); err != nil {
+			return nil, err
+		}
+		fmt.Printf("%s: Pull complete\n", shortDigest(layer.Digest))
+	}
+
+	var refs []string
+	if ref.Tag != "" {
+		refs = append(refs, ref.Name()+":"+ref.Tag)
+	}
+
+	img, err := image.CreateImage(content, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Digest: %s\n", digest)
+	fmt.Printf("Status: Downloaded image for %s\n", ref)
+	return img, nil
+}
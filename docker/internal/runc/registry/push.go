@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"bytes"
+	"docker/internal/runc/image"
+	"docker/internal/utils/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// chunkSize is the size of each PATCH of a chunked blob upload.
+const chunkSize = 5 << 20
+
+func (c *Client) blobExists(repository, digest, scope string) (bool, error) {
+	resp, err := c.do(http.MethodHead, "/v2/"+repository+"/blobs/"+digest, scope, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("minidocker: HEAD blob %v returned %v", digest, resp.Status)
+}
+
+func bodyOf(content []byte) func() (io.Reader, int64) {
+	return func() (io.Reader, int64) {
+		return bytes.NewReader(content), int64(len(content))
+	}
+}
+
+// pushBlob uploads the blob in chunks, each PATCH continues at the Location
+// returned by the previous request and the final PUT commits the digest.
+func (c *Client) pushBlob(repository, digest string, r io.Reader, scope string) error {
+	exist, err := c.blobExists(repository, digest, scope)
+	if err != nil {
+		return err
+	}
+	if exist {
+		fmt.Printf("%s: Layer already exists\n", shortDigest(digest))
+		return nil
+	}
+
+	resp, err := c.do(http.MethodPost, "/v2/"+repository+"/blobs/uploads/", scope, nil, bodyOf(nil))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return statusError(resp)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+
+	buffer := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buffer)
+		if n > 0 {
+			header := http.Header{
+				"Content-Type":  {"application/octet-stream"},
+				"Content-Range": {fmt.Sprintf("%d-%d", offset, offset+int64(n)-1)},
+			}
+			resp, err := c.do(http.MethodPatch, location, scope, header, bodyOf(buffer[:n]))
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+				return statusError(resp)
+			}
+			resp.Body.Close()
+
+			if next := resp.Header.Get("Location"); next != "" {
+				location = next
+			}
+			offset += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("minidocker: read blob %v failed [%v]", digest, err)
+		}
+	}
+
+	u, err := c.resolve(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err = c.do(http.MethodPut, u.String(), scope, header, bodyOf(nil))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return statusError(resp)
+	}
+	resp.Body.Close()
+
+	fmt.Printf("%s: Pushed\n", shortDigest(digest))
+	return nil
+}
+
+func (c *Client) pushLayer(repository, digest, scope string) error {
+	file, err := os.Open(image.LayerTarPath(digest))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return c.pushBlob(repository, digest, file, scope)
+}
+
+// Push uploads the layers, the config and the manifest of a local image.
+func Push(name string) error {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return err
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("minidocker: can not push by digest %v", name)
+	}
+
+	img, err := image.Lookup(ref.Name() + ":" + ref.Tag)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := img.OCIManifest()
+	if err != nil {
+		return err
+	}
+
+	config, err := img.RawConfig()
+	if err != nil {
+		return err
+	}
+
+	c := NewClient(ref.Host())
+	scope := pushScope(ref.Repository)
+
+	for _, layer := range manifest.Layers {
+		if err := c.pushLayer(ref.Repository, layer.Digest, scope); err != nil {
+			return err
+		}
+	}
+
+	if err := c.pushBlob(ref.Repository, manifest.Config.Digest, bytes.NewReader(config), scope); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{"Content-Type": {image.MediaTypeOCIManifest}}
+	resp, err := c.do(http.MethodPut, "/v2/"+ref.Repository+"/manifests/"+ref.Tag, scope, header, bodyOf(content))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return statusError(resp)
+	}
+	resp.Body.Close()
+
+	fmt.Printf("%s: digest: %s size: %d\n", ref.Tag, digestOf(content), len(content))
+	return nil
+}
+
+// Login verifies the credentials against the registry and stores them.
+func Login(host, username, password string) error {
+	registry := host
+	if registry == defaultDomain {
+		registry = defaultRegistry
+	}
+
+	c := NewClient(registry)
+	c.username, c.password = username, password
+
+	if err := c.Ping(); err != nil {
+		return err
+	}
+
+	return config.SaveCredentials(host, username, password)
+}
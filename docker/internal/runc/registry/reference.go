@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultDomain   = "docker.io"
+	defaultRegistry = "registry-1.docker.io"
+	officialPrefix  = "library/"
+	defaultTag      = "latest"
+)
+
+// Reference is an image reference such as localhost:5000/app:v1 or
+// busybox@sha256:..., docker.io images are pulled from docker hub.
+type Reference struct {
+	Domain     string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func ParseReference(ref string) (*Reference, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("minidocker: empty image reference")
+	}
+
+	reference := &Reference{Domain: defaultDomain}
+
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference.Digest = name[:i], name[i+1:]
+		if !strings.HasPrefix(reference.Digest, "sha256:") {
+			return nil, fmt.Errorf("minidocker: unsupported digest %v", reference.Digest)
+		}
+	}
+
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, reference.Tag = name[:i], name[i+1:]
+	}
+
+	if i := strings.Index(name, "/"); i >= 0 {
+		domain := name[:i]
+		if strings.ContainsAny(domain, ".:") || domain == "localhost" {
+			reference.Domain, name = domain, name[i+1:]
+		}
+	}
+
+	if reference.Domain == defaultDomain && !strings.Contains(name, "/") {
+		name = officialPrefix + name
+	}
+
+	if name == "" || name != strings.ToLower(name) {
+		return nil, fmt.Errorf("minidocker: invalid image reference %v", ref)
+	}
+	reference.Repository = name
+
+	if reference.Tag == "" && reference.Digest == "" {
+		reference.Tag = defaultTag
+	}
+
+	return reference, nil
+}
+
+// Host is the registry host serving the domain.
+func (ref *Reference) Host() string {
+	if ref.Domain == defaultDomain {
+		return defaultRegistry
+	}
+
+	return ref.Domain
+}
+
+// Name is the short name used in the local image store, docker.io and the
+// library prefix are left out as docker does.
+func (ref *Reference) Name() string {
+	if ref.Domain == defaultDomain {
+		return strings.TrimPrefix(ref.Repository, officialPrefix)
+	}
+
+	return ref.Domain + "/" + ref.Repository
+}
+
+// Object is the tag or digest part of the manifest URL.
+func (ref *Reference) Object() string {
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+
+	return ref.Tag
+}
+
+func (ref *Reference) String() string {
+	if ref.Digest != "" {
+		return ref.Name() + "@" + ref.Digest
+	}
+
+	return ref.Name() + ":" + ref.Tag
+}
@@ -0,0 +1,112 @@
+package registry
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		ref    string
+		want   Reference
+		host   string
+		name   string
+		object string
+	}{
+		{
+			ref:    "busybox",
+			want:   Reference{Domain: "docker.io", Repository: "library/busybox", Tag: "latest"},
+			host:   "registry-1.docker.io",
+			name:   "busybox",
+			object: "latest",
+		},
+		{
+			ref:    "library/busybox:1.36",
+			want:   Reference{Domain: "docker.io", Repository: "library/busybox", Tag: "1.36"},
+			host:   "registry-1.docker.io",
+			name:   "busybox",
+			object: "1.36",
+		},
+		{
+			ref:    "docker.io/user/app",
+			want:   Reference{Domain: "docker.io", Repository: "user/app", Tag: "latest"},
+			host:   "registry-1.docker.io",
+			name:   "user/app",
+			object: "latest",
+		},
+		{
+			ref:    "localhost:5000/app",
+			want:   Reference{Domain: "localhost:5000", Repository: "app", Tag: "latest"},
+			host:   "localhost:5000",
+			name:   "localhost:5000/app",
+			object: "latest",
+		},
+		{
+			ref:    "localhost:5000/team/app:v1",
+			want:   Reference{Domain: "localhost:5000", Repository: "team/app", Tag: "v1"},
+			host:   "localhost:5000",
+			name:   "localhost:5000/team/app",
+			object: "v1",
+		},
+		{
+			ref:    "localhost/app:v1",
+			want:   Reference{Domain: "localhost", Repository: "app", Tag: "v1"},
+			host:   "localhost",
+			name:   "localhost/app",
+			object: "v1",
+		},
+		{
+			ref:    "registry.example.com/app",
+			want:   Reference{Domain: "registry.example.com", Repository: "app", Tag: "latest"},
+			host:   "registry.example.com",
+			name:   "registry.example.com/app",
+			object: "latest",
+		},
+		{
+			ref:    "busybox@" + digest,
+			want:   Reference{Domain: "docker.io", Repository: "library/busybox", Digest: digest},
+			host:   "registry-1.docker.io",
+			name:   "busybox",
+			object: digest,
+		},
+		{
+			ref:    "localhost:5000/app:v1@" + digest,
+			want:   Reference{Domain: "localhost:5000", Repository: "app", Tag: "v1", Digest: digest},
+			host:   "localhost:5000",
+			name:   "localhost:5000/app",
+			object: digest,
+		},
+	}
+
+	for _, test := range tests {
+		ref, err := ParseReference(test.ref)
+		if err != nil {
+			t.Errorf("ParseReference(%v) failed: %v", test.ref, err)
+			continue
+		}
+		if *ref != test.want {
+			t.Errorf("ParseReference(%v) = %+v, want %+v", test.ref, *ref, test.want)
+		}
+		if ref.Host() != test.host {
+			t.Errorf("Host of %v = %v, want %v", test.ref, ref.Host(), test.host)
+		}
+		if ref.Name() != test.name {
+			t.Errorf("Name of %v = %v, want %v", test.ref, ref.Name(), test.name)
+		}
+		if ref.Object() != test.object {
+			t.Errorf("Object of %v = %v, want %v", test.ref, ref.Object(), test.object)
+		}
+	}
+}
+
+func TestParseReferenceInvalid(t *testing.T) {
+	for _, ref := range []string{
+		"",
+		"Busybox",
+		"busybox@md5:abc",
+		"localhost:5000/",
+	} {
+		if _, err := ParseReference(ref); err == nil {
+			t.Errorf("ParseReference(%q) succeeded", ref)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bufio"
+	"docker/internal/utils/archive"
+	"docker/internal/utils/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	DefaultDriver = "overlay"
+
+	metaName = "layer.json"
+)
+
+// Driver manages the writable rootfs of containers on top of the image
+// layers, which are given as lowerdirs with the top layer first.
+type Driver interface {
+	Name() string
+	// Create prepares the writable layer of id on top of the lowerdirs,
+	// options may be nil.
+	Create(id string, lowerdirs []string, options *Options) error
+	// Mount returns the path of the rootfs of id, mounting it if needed.
+	Mount(id string) (string, error)
+	Unmount(id string) error
+	// Diff writes the changes of the writable layer as a layer tarball.
+	Diff(id string, w io.Writer) error
+	// Changes lists the paths the writable layer adds, changes or deletes.
+	Changes(id string) ([]archive.Change, error)
+	Remove(id string) error
+	// Usage reports the space taken by the writable layer and its limit.
+	Usage(id string) (*Usage, error)
+}
+
+// Usage is the size of a writable layer, Limit is zero without a limit.
+type Usage struct {
+	Size  int64 `json:"size"`
+	Limit int64 `json:"limit,omitempty"`
+}
+
+var drivers = map[string]func(home string) Driver{
+	"overlay": newOverlayDriver,
+	"vfs":     newVfsDriver,
+}
+
+// home is the directory of the layers of a driver.
+func home(name string) string {
+	return config.DataPath("storage", name)
+}
+
+// GetDriver returns the driver by name, the containers record the driver
+// which created their rootfs.
+func GetDriver(name string) (Driver, error) {
+	if name == "" {
+		name = DefaultDriver
+	}
+
+	newDriver, exist := drivers[name]
+	if !exist {
+		return nil, fmt.Errorf("minidocker: unknown storage driver %v", name)
+	}
+
+	return newDriver(home(name)), nil
+}
+
+// Default returns the configured driver, without one overlay is probed and
+// vfs is used when overlay can not be mounted.
+func Default() (Driver, error) {
+	if name := config.Get().StorageDriver; name != "" {
+		return GetDriver(name)
+	}
+
+	overlay := newOverlayDriver(home("overlay")).(*overlayDriver)
+	if err := overlay.probe(); err != nil {
+		log.Warnf("overlay is not supported, falling back to vfs: %v", err)
+		return GetDriver("vfs")
+	}
+
+	return overlay, nil
+}
+
+// layerMeta records how the layer of id was created.
+type layerMeta struct {
+	Lowerdirs []string `json:"lowerdirs"`
+	Size      int64    `json:"size,omitempty"`
+}
+
+func writeMeta(dir string, meta *layerMeta) error {
+	content, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, metaName), content, 0644)
+}
+
+func readMeta(dir string) (*layerMeta, error) {
+	content, err := os.ReadFile(filepath.Join(dir, metaName))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta layerMeta
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("minidocker: parse layer meta of %v failed [%v]", dir, err)
+	}
+
+	return &meta, nil
+}
+
+// checkLowerdirs makes sure that every layer exists, a layer which failed to
+// extract must not be used as an empty directory.
+func checkLowerdirs(lowerdirs []string) error {
+	for _, lowerdir := range lowerdirs {
+		if _, err := os.Stat(lowerdir); err != nil {
+			return fmt.Errorf("minidocker: image layer %v is missing [%v]", lowerdir, err)
+		}
+	}
+
+	return nil
+}
+
+// DiffReader streams the diff of id, the reader must be closed.
+func DiffReader(driver Driver, id string) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(driver.Diff(id, w))
+	}()
+
+	return r
+}
+
+// mounted tells whether dir is a mount point of this mount namespace.
+func mounted(dir string) bool {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// the fifth field is the mount point, spaces are escaped as \040
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && strings.ReplaceAll(fields[4], "\\040", " ") == dir {
+			return true
+		}
+	}
+
+	return false
+}
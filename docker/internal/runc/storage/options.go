@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Options are the --storage-opt of a container.
+type Options struct {
+	// Size caps the writable layer in bytes, zero means no limit.
+	Size int64
+}
+
+// ParseOptions parses the key=value storage options, size accepts the
+// units k, m, g and t in powers of 1024 such as 2G.
+func ParseOptions(opts []string) (*Options, error) {
+	options := &Options{}
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("minidocker: invalid storage option %v, expected key=value", opt)
+		}
+
+		switch strings.ToLower(key) {
+		case "size":
+			size, err := ParseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			options.Size = size
+		default:
+			return nil, fmt.Errorf("minidocker: unknown storage option %v", key)
+		}
+	}
+
+	return options, nil
+}
+
+// ParseSize parses a size such as 64m, the units k, m, g and t are powers
+// of 1024.
+func ParseSize(value string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToLower(value), "b")
+	shift := 0
+	if n := len(number); n > 0 {
+		if i := strings.IndexByte("kmgt", number[n-1]); i >= 0 {
+			number, shift = number[:n-1], 10*(i+1)
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("minidocker: invalid size %v", value)
+	}
+
+	return int64(size * float64(int64(1)<<shift)), nil
+}
+
+// size returns the limit of the options, nil options have no limit.
+func (options *Options) size() int64 {
+	if options == nil {
+		return 0
+	}
+
+	return options.Size
+}
+
+// mountQuota backs dir with a tmpfs of size bytes, everything the driver
+// keeps in dir counts towards the limit and writes beyond it fail with
+// ENOSPC. The content of a tmpfs does not survive a reboot.
+func mountQuota(dir string, size int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return nil
+	}
+
+	data := "size=" + strconv.FormatInt(size, 10) + ",mode=755"
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+		return fmt.Errorf("minidocker: mount size limited tmpfs %v failed [%v]", dir, err)
+	}
+
+	return nil
+}
+
+// removeLayer removes dir with its tmpfs, if it has one.
+func removeLayer(dir string) error {
+	if mounted(dir) {
+		if err := syscall.Unmount(dir, syscall.MNT_DETACH); err != nil {
+			return fmt.Errorf("minidocker: unmount %v failed [%v]", dir, err)
+		}
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// usage measures dir, a size limited layer is measured by its tmpfs.
+func usage(dir, layer string) (*Usage, error) {
+	meta, err := readMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Size != 0 {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(dir, &stat); err != nil {
+			return nil, err
+		}
+		return &Usage{Size: int64(stat.Blocks-stat.Bfree) * stat.Bsize, Limit: meta.Size}, nil
+	}
+
+	var size int64
+	filepath.Walk(filepath.Join(dir, layer), func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return &Usage{Size: size}, nil
+}
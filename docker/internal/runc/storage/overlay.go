@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"docker/internal/utils/archive"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+const (
+	upperName = "diff"
+	workName  = "work"
+	mergeName = "merged"
+	// emptyName is the lowerdir of a rootfs without image layers, overlayfs
+	// requires at least one.
+	emptyName = "empty"
+	// linkDir keeps short symlinks to the lowerdirs, the mount data is
+	// limited to a page and the full layer paths only fit a few dozens.
+	linkDir = "l"
+)
+
+// overlayDriver keeps the changes of a container in the upper directory of
+// an overlay mount of the image layers.
+type overlayDriver struct {
+	home string
+}
+
+func newOverlayDriver(home string) Driver {
+	return &overlayDriver{home: home}
+}
+
+func (d *overlayDriver) Name() string {
+	return "overlay"
+}
+
+func (d *overlayDriver) dir(id string, elem ...string) string {
+	return filepath.Join(append([]string{d.home, id}, elem...)...)
+}
+
+// mountOverlay mounts the lowerdirs, relative to dir, from dir. The current
+// directory is shared by all the threads, so the mount runs on a thread
+// which unshares it and exits afterwards.
+func mountOverlay(dir string, lowerdirs []string, upperdir, workdir, mergedir string) error {
+	data := "lowerdir=" + strings.Join(lowerdirs, ":") + ",upperdir=" + upperdir + ",workdir=" + workdir
+
+	errc := make(chan error, 1)
+	go func() {
+		// the thread is not unlocked, it ends with the goroutine
+		runtime.LockOSThread()
+
+		if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+			errc <- err
+			return
+		}
+		if err := syscall.Chdir(dir); err != nil {
+			errc <- err
+			return
+		}
+
+		errc <- syscall.Mount("overlay", mergedir, "overlay", 0, data)
+	}()
+
+	if err := <-errc; err != nil {
+		return fmt.Errorf("minidocker: mount overlay %v failed [%v]", mergedir, err)
+	}
+
+	return nil
+}
+
+// linkName is the short name of the link to lowerdir, the containers of an
+// image share the links of its layers.
+func linkName(lowerdir string) string {
+	sum := sha256.Sum256([]byte(lowerdir))
+	return filepath.Join(linkDir, hex.EncodeToString(sum[:])[:12])
+}
+
+// link returns the lowerdirs as links relative to the home of the driver.
+func (d *overlayDriver) link(lowerdirs []string) ([]string, error) {
+	if err := os.MkdirAll(filepath.Join(d.home, linkDir), 0700); err != nil {
+		return nil, err
+	}
+
+	links := make([]string, 0, len(lowerdirs))
+	for _, lowerdir := range lowerdirs {
+		name := linkName(lowerdir)
+		link := filepath.Join(d.home, name)
+		if target, err := os.Readlink(link); err != nil || target != lowerdir {
+			os.Remove(link)
+			if err := os.Symlink(lowerdir, link); err != nil && !os.IsExist(err) {
+				return nil, fmt.Errorf("minidocker: link lowerdir %v failed [%v]", lowerdir, err)
+			}
+		}
+		links = append(links, name)
+	}
+
+	return links, nil
+}
+
+// pruneLinks removes the links to the layers which were removed.
+func (d *overlayDriver) pruneLinks() {
+	entries, err := os.ReadDir(filepath.Join(d.home, linkDir))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		link := filepath.Join(d.home, linkDir, entry.Name())
+		if _, err := os.Stat(link); os.IsNotExist(err) {
+			os.Remove(link)
+		}
+	}
+}
+
+// probe mounts a throwaway overlay to tell whether the kernel and the
+// filesystem of the data root support overlay.
+func (d *overlayDriver) probe() error {
+	if err := os.MkdirAll(d.home, 0755); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp(d.home, "probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	dirs := map[string]string{}
+	for _, name := range []string{"lower", upperName, workName, mergeName} {
+		dirs[name] = filepath.Join(dir, name)
+		if err := os.Mkdir(dirs[name], 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := mountOverlay(dir, []string{"lower"}, dirs[upperName], dirs[workName], dirs[mergeName]); err != nil {
+		return err
+	}
+
+	return syscall.Unmount(dirs[mergeName], syscall.MNT_DETACH)
+}
+
+// Create keeps the upper and work directories on a size limited tmpfs when
+// the options have a size.
+func (d *overlayDriver) Create(id string, lowerdirs []string, options *Options) error {
+	if err := checkLowerdirs(lowerdirs); err != nil {
+		return err
+	}
+	d.pruneLinks()
+
+	if err := mountQuota(d.dir(id), options.size()); err != nil {
+		return err
+	}
+
+	for _, name := range []string{upperName, workName, mergeName} {
+		if err := os.MkdirAll(d.dir(id, name), 0755); err != nil {
+			return err
+		}
+	}
+
+	if len(lowerdirs) == 0 {
+		if err := os.Mkdir(d.dir(id, emptyName), 0755); err != nil {
+			return err
+		}
+	}
+
+	return writeMeta(d.dir(id), &layerMeta{Lowerdirs: lowerdirs, Size: options.size()})
+}
+
+func (d *overlayDriver) lowerdirs(id string) ([]string, error) {
+	meta, err := readMeta(d.dir(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(meta.Lowerdirs) == 0 {
+		return []string{d.dir(id, emptyName)}, nil
+	}
+
+	return meta.Lowerdirs, nil
+}
+
+func (d *overlayDriver) Mount(id string) (string, error) {
+	mergedir := d.dir(id, mergeName)
+	if mounted(mergedir) {
+		return mergedir, nil
+	}
+
+	lowerdirs, err := d.lowerdirs(id)
+	if err != nil {
+		return "", err
+	}
+
+	links, err := d.link(lowerdirs)
+	if err != nil {
+		return "", err
+	}
+
+	if err := mountOverlay(d.home, links, d.dir(id, upperName), d.dir(id, workName), mergedir); err != nil {
+		return "", err
+	}
+
+	return mergedir, nil
+}
+
+func (d *overlayDriver) Unmount(id string) error {
+	mergedir := d.dir(id, mergeName)
+	if !mounted(mergedir) {
+		return nil
+	}
+
+	return syscall.Unmount(mergedir, syscall.MNT_DETACH)
+}
+
+// Diff writes the upper directory, the whiteouts of overlayfs are written
+// as .wh. entries.
+func (d *overlayDriver) Diff(id string, w io.Writer) error {
+	return archive.Tar(d.dir(id, upperName), w, &archive.Options{OverlayWhiteouts: true})
+}
+
+func (d *overlayDriver) Changes(id string) ([]archive.Change, error) {
+	lowerdirs, err := d.lowerdirs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return archive.OverlayChanges(d.dir(id, upperName), lowerdirs)
+}
+
+func (d *overlayDriver) Remove(id string) error {
+	if err := d.Unmount(id); err != nil {
+		return err
+	}
+
+	return removeLayer(d.dir(id))
+}
+
+func (d *overlayDriver) Usage(id string) (*Usage, error) {
+	return usage(d.dir(id), upperName)
+}
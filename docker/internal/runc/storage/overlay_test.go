@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverlayMountManyLayers(t *testing.T) {
+	root := t.TempDir()
+	d := newOverlayDriver(filepath.Join(root, "overlay")).(*overlayDriver)
+	if err := d.probe(); err != nil {
+		t.Skipf("overlay is not supported: %v", err)
+	}
+
+	// the full paths of the layers take far more than a page
+	var lowerdirs []string
+	for i := 0; i < 128; i++ {
+		lowerdir := filepath.Join(root, "image", "layers", fmt.Sprintf("%064x", i), "diff")
+		if err := os.MkdirAll(lowerdir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(lowerdir, fmt.Sprint("layer", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		lowerdirs = append(lowerdirs, lowerdir)
+	}
+	if size := len(strings.Join(lowerdirs, ":")); size < os.Getpagesize() {
+		t.Fatalf("the lowerdirs take %v bytes only", size)
+	}
+
+	if err := d.Create("c1", lowerdirs, &Options{}); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	defer d.Remove("c1")
+
+	merged, err := d.Mount("c1")
+	if err != nil {
+		t.Fatalf("mount failed: %v", err)
+	}
+	for _, name := range []string{"layer0", "layer127"} {
+		if _, err := os.Lstat(filepath.Join(merged, name)); err != nil {
+			t.Errorf("%v is missing from the rootfs: %v", name, err)
+		}
+	}
+
+	// the thread which mounted did not move the current directory
+	wd, err := os.Getwd()
+	if err != nil || wd == d.home {
+		t.Fatalf("the current directory is %v, %v", wd, err)
+	}
+
+	if err := d.Unmount("c1"); err != nil {
+		t.Fatalf("unmount failed: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"docker/internal/utils/archive"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const rootfsName = "rootfs"
+
+// vfsDriver makes a full copy of the image layers for every container, it
+// works on any filesystem at the cost of space and time.
+type vfsDriver struct {
+	home string
+}
+
+func newVfsDriver(home string) Driver {
+	return &vfsDriver{home: home}
+}
+
+func (d *vfsDriver) Name() string {
+	return "vfs"
+}
+
+func (d *vfsDriver) dir(id string, elem ...string) string {
+	return filepath.Join(append([]string{d.home, id}, elem...)...)
+}
+
+// copyLayer applies a layer onto the rootfs, its whiteouts delete the files
+// of the layers below.
+func copyLayer(lowerdir, rootfs string) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(archive.Tar(lowerdir, w, &archive.Options{OverlayWhiteouts: true}))
+	}()
+	defer r.Close()
+
+	return archive.Untar(r, rootfs, &archive.Options{ApplyWhiteouts: true})
+}
+
+// Create copies the layers onto a size limited tmpfs when the options have
+// a size, the copy of the image counts towards the limit.
+func (d *vfsDriver) Create(id string, lowerdirs []string, options *Options) error {
+	if err := checkLowerdirs(lowerdirs); err != nil {
+		return err
+	}
+
+	if err := mountQuota(d.dir(id), options.size()); err != nil {
+		return err
+	}
+
+	rootfs := d.dir(id, rootfsName)
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+
+	for i := len(lowerdirs) - 1; i >= 0; i-- {
+		if err := copyLayer(lowerdirs[i], rootfs); err != nil {
+			removeLayer(d.dir(id))
+			return err
+		}
+	}
+
+	return writeMeta(d.dir(id), &layerMeta{Lowerdirs: lowerdirs, Size: options.size()})
+}
+
+// Mount bind mounts the copy onto itself, the volumes mounted below the
+// rootfs are then detached together with it instead of being removed.
+func (d *vfsDriver) Mount(id string) (string, error) {
+	rootfs := d.dir(id, rootfsName)
+	if mounted(rootfs) {
+		return rootfs, nil
+	}
+
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND, ""); err != nil {
+		return "", fmt.Errorf("minidocker: bind mount %v failed [%v]", rootfs, err)
+	}
+
+	return rootfs, nil
+}
+
+func (d *vfsDriver) Unmount(id string) error {
+	rootfs := d.dir(id, rootfsName)
+	if !mounted(rootfs) {
+		return nil
+	}
+
+	return syscall.Unmount(rootfs, syscall.MNT_DETACH)
+}
+
+// Changes compares the copy with the image layers, which is slower than the
+// upper directory of overlay.
+func (d *vfsDriver) Changes(id string) ([]archive.Change, error) {
+	meta, err := readMeta(d.dir(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return archive.ChangesDirs(d.dir(id, rootfsName), meta.Lowerdirs)
+}
+
+func (d *vfsDriver) Diff(id string, w io.Writer) error {
+	changes, err := d.Changes(id)
+	if err != nil {
+		return err
+	}
+
+	return archive.ExportChanges(d.dir(id, rootfsName), changes, w)
+}
+
+func (d *vfsDriver) Remove(id string) error {
+	if err := d.Unmount(id); err != nil {
+		return err
+	}
+
+	return removeLayer(d.dir(id))
+}
+
+func (d *vfsDriver) Usage(id string) (*Usage, error) {
+	return usage(d.dir(id), rootfsName)
+}
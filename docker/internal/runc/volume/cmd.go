@@ -0,0 +1,115 @@
+package volume
+
+import (
+	"docker/internal/runc/image"
+	"docker/internal/utils/cmdtable"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gosuri/uitable"
+)
+
+// parseLabels parses the key=value labels or options, a label without value
+// is empty.
+func parseLabels(labels []string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	parsed := map[string]string{}
+	for _, label := range labels {
+		key, value, _ := strings.Cut(label, "=")
+		parsed[key] = value
+	}
+
+	return parsed
+}
+
+// RunVolumeCreate creates the volume with the driver, the driver options are
+// passed to a volume plugin as is.
+func RunVolumeCreate(name, driver string, labels, opts []string) error {
+	v, err := Create(name, driver, parseLabels(labels), parseLabels(opts))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(v.Name)
+	return nil
+}
+
+func RunVolumeList() error {
+	volumes, err := List()
+	if err != nil {
+		return fmt.Errorf("minidocker: list volumes failed [%v]", err)
+	}
+
+	table := uitable.New()
+	table.AddRow("DRIVER", "VOLUME NAME")
+	for _, v := range volumes {
+		table.AddRow(v.Driver, v.Name)
+	}
+
+	return cmdtable.EncodeTable(os.Stdout, table)
+}
+
+func RunVolumeInspect(names []string) error {
+	volumes := []*Volume{}
+	for _, name := range names {
+		v, err := find(name)
+		if err != nil {
+			return err
+		}
+		// a plugin tells the current mountpoint of its volume
+		if p, err := v.plugin(); err != nil {
+			return err
+		} else if p != nil {
+			if v.Mountpoint, err = p.Path(name); err != nil {
+				return err
+			}
+		}
+		volumes = append(volumes, v)
+	}
+
+	content, err := json.MarshalIndent(volumes, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(content))
+	return nil
+}
+
+// RunVolumeRemove removes the volumes, force ignores the missing ones.
+func RunVolumeRemove(names []string, force bool) error {
+	for _, name := range names {
+		if _, err := find(name); err != nil && force {
+			continue
+		}
+
+		if err := Remove(name); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+
+	return nil
+}
+
+func RunVolumePrune() error {
+	removed, reclaimed, err := Prune()
+	if len(removed) != 0 {
+		fmt.Println("Deleted Volumes:")
+		for _, name := range removed {
+			fmt.Println(name)
+		}
+		fmt.Println()
+	}
+	if err != nil {
+		return fmt.Errorf("minidocker: prune volumes failed [%v]", err)
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", image.HumanSize(reclaimed))
+	return nil
+}
@@ -0,0 +1,181 @@
+package volume
+
+import (
+	"bytes"
+	"context"
+	"docker/internal/utils/config"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	pluginContentType = "application/vnd.docker.plugins.v1+json"
+	pluginTimeout     = 30 * time.Second
+)
+
+// pluginsPath returns the directory where the volume plugins listen, a
+// plugin named NAME listens on NAME.sock.
+func pluginsPath(elem ...string) string {
+	return config.RootPath(append([]string{"plugins"}, elem...)...)
+}
+
+// plugin is a volume driver speaking the docker volume plugin protocol, a
+// JSON POST per call over its Unix socket.
+type plugin struct {
+	name   string
+	client *http.Client
+}
+
+// pluginVolume is a volume as reported by a plugin.
+type pluginVolume struct {
+	Name       string
+	Mountpoint string
+}
+
+// pluginResponse is the reply of every call, Err is set on failure.
+type pluginResponse struct {
+	Err        string
+	Mountpoint string
+	Volumes    []pluginVolume
+	Implements []string
+}
+
+// getPlugin connects to the plugin and checks it implements a volume
+// driver.
+func getPlugin(name string) (*plugin, error) {
+	if !IsName(name) {
+		return nil, fmt.Errorf("minidocker: invalid volume driver name %v", name)
+	}
+
+	socket := pluginsPath(name + ".sock")
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("minidocker: volume driver %v not found in %v", name, pluginsPath())
+	}
+
+	p := &plugin{
+		name: name,
+		client: &http.Client{
+			Timeout: pluginTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+
+	resp, err := p.call("Plugin.Activate", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	for _, implement := range resp.Implements {
+		if implement == "VolumeDriver" {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("minidocker: plugin %v is not a volume driver", name)
+}
+
+// listPlugins returns the names of the plugins in the plugins directory.
+func listPlugins() ([]string, error) {
+	matches, err := filepath.Glob(pluginsPath("*.sock"))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), ".sock"))
+	}
+
+	return names, nil
+}
+
+func (p *plugin) call(method string, request interface{}) (*pluginResponse, error) {
+	content, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// the host is ignored by the transport, which always dials the socket
+	resp, err := p.client.Post("http://plugin/"+method, pluginContentType, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: call %v of volume driver %v failed [%v]", method, p.name, err)
+	}
+	defer resp.Body.Close()
+
+	content, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("minidocker: read %v of volume driver %v failed [%v]", method, p.name, err)
+	}
+
+	var response pluginResponse
+	if err := json.Unmarshal(content, &response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("minidocker: %v of volume driver %v returned %v: %s", method, p.name, resp.Status, strings.TrimSpace(string(content)))
+		}
+		return nil, fmt.Errorf("minidocker: parse %v of volume driver %v failed [%v]", method, p.name, err)
+	}
+
+	if response.Err != "" {
+		return nil, fmt.Errorf("minidocker: %v of volume driver %v failed [%v]", method, p.name, response.Err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("minidocker: %v of volume driver %v returned %v", method, p.name, resp.Status)
+	}
+
+	return &response, nil
+}
+
+func (p *plugin) Create(name string, opts map[string]string) error {
+	_, err := p.call("VolumeDriver.Create", map[string]interface{}{"Name": name, "Opts": opts})
+	return err
+}
+
+func (p *plugin) Remove(name string) error {
+	_, err := p.call("VolumeDriver.Remove", map[string]string{"Name": name})
+	return err
+}
+
+// Mount asks the plugin to make the volume available to the container id
+// and returns where it is on the host.
+func (p *plugin) Mount(name, id string) (string, error) {
+	resp, err := p.call("VolumeDriver.Mount", map[string]string{"Name": name, "ID": id})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Mountpoint, nil
+}
+
+func (p *plugin) Unmount(name, id string) error {
+	_, err := p.call("VolumeDriver.Unmount", map[string]string{"Name": name, "ID": id})
+	return err
+}
+
+func (p *plugin) Path(name string) (string, error) {
+	resp, err := p.call("VolumeDriver.Path", map[string]string{"Name": name})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Mountpoint, nil
+}
+
+func (p *plugin) List() ([]pluginVolume, error) {
+	resp, err := p.call("VolumeDriver.List", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Volumes, nil
+}
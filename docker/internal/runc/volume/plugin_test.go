@@ -0,0 +1,208 @@
+package volume
+
+import (
+	"docker/internal/utils/config"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDriver is a volume plugin keeping its volumes in memory, it records
+// the calls it receives.
+type fakeDriver struct {
+	dir     string
+	mu      sync.Mutex
+	calls   []string
+	volumes map[string]bool
+}
+
+func (d *fakeDriver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct{ Name, ID string }
+	json.NewDecoder(r.Body).Decode(&req)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	method := strings.TrimPrefix(r.URL.Path, "/")
+	d.calls = append(d.calls, strings.TrimSpace(method+" "+req.Name+" "+req.ID))
+
+	resp := map[string]interface{}{}
+	known := d.volumes[req.Name]
+	switch method {
+	case "Plugin.Activate":
+		resp["Implements"] = []string{"VolumeDriver"}
+	case "VolumeDriver.Create":
+		d.volumes[req.Name] = true
+	case "VolumeDriver.Remove":
+		delete(d.volumes, req.Name)
+	case "VolumeDriver.Mount", "VolumeDriver.Path":
+		resp["Mountpoint"] = filepath.Join(d.dir, req.Name)
+	case "VolumeDriver.Unmount":
+	case "VolumeDriver.List":
+		var volumes []map[string]string
+		for name := range d.volumes {
+			volumes = append(volumes, map[string]string{"Name": name})
+		}
+		resp["Volumes"] = volumes
+	default:
+		resp["Err"] = "unknown method " + method
+	}
+	if _, ok := resp["Err"]; !ok && method != "VolumeDriver.Create" && req.Name != "" && !known {
+		resp["Err"] = "no such volume " + req.Name
+	}
+
+	w.Header().Set("Content-Type", pluginContentType)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// takeCalls returns the calls but the activations and forgets them.
+func (d *fakeDriver) takeCalls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var calls []string
+	for _, call := range d.calls {
+		if call != "Plugin.Activate" {
+			calls = append(calls, call)
+		}
+	}
+	d.calls = nil
+
+	return calls
+}
+
+func startFakeDriver(t *testing.T, name string) *fakeDriver {
+	t.Helper()
+
+	root := t.TempDir()
+	config.SetRoot(root)
+	config.SetDataRoot(filepath.Join(root, "data"))
+
+	if err := os.MkdirAll(pluginsPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", pluginsPath(name+".sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &fakeDriver{dir: filepath.Join(root, "fake"), volumes: map[string]bool{}}
+	server := &http.Server{Handler: d}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return d
+}
+
+func expectCalls(t *testing.T, d *fakeDriver, want ...string) {
+	t.Helper()
+
+	if got := d.takeCalls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestPluginVolumeLifecycle(t *testing.T) {
+	d := startFakeDriver(t, "fake")
+
+	v, err := Create("data", "fake", nil, map[string]string{"size": "1g"})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if v.Driver != "fake" {
+		t.Fatalf("driver = %v, want fake", v.Driver)
+	}
+	expectCalls(t, d, "VolumeDriver.Create data")
+
+	if v, err = Acquire("data", "c1"); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	if want := filepath.Join(d.dir, "data"); v.Mountpoint != want {
+		t.Fatalf("mountpoint = %v, want %v", v.Mountpoint, want)
+	}
+	expectCalls(t, d, "VolumeDriver.Mount data c1")
+
+	if err := Remove("data"); err == nil {
+		t.Fatal("remove of a volume in use succeeded")
+	}
+	d.takeCalls()
+
+	// the container exits, then it is removed
+	if err := Unmount("data", "c1"); err != nil {
+		t.Fatalf("unmount failed: %v", err)
+	}
+	expectCalls(t, d, "VolumeDriver.Unmount data c1")
+
+	if err := Release("data", "c1"); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	expectCalls(t, d)
+
+	if err := Remove("data"); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	expectCalls(t, d, "VolumeDriver.Remove data")
+
+	if _, err := Get("data"); err == nil {
+		t.Fatal("the volume is kept after remove")
+	}
+}
+
+func TestPluginVolumeReleaseUnmounts(t *testing.T) {
+	d := startFakeDriver(t, "fake")
+
+	if _, err := Create("data", "fake", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire("data", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	d.takeCalls()
+
+	if err := Release("data", "c1"); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	expectCalls(t, d, "VolumeDriver.Unmount data c1")
+}
+
+func TestPruneKeepsForeignPluginVolumes(t *testing.T) {
+	d := startFakeDriver(t, "fake")
+	d.volumes["foreign"] = true
+
+	if _, err := Create("ours", "fake", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("list returned %v volumes, want 2", len(volumes))
+	}
+
+	removed, _, err := Prune()
+	if err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"ours"}) {
+		t.Fatalf("prune removed %v, want [ours]", removed)
+	}
+	if !d.volumes["foreign"] {
+		t.Fatal("prune removed a volume minidocker did not create")
+	}
+}
+
+func TestCreateUnknownDriver(t *testing.T) {
+	startFakeDriver(t, "fake")
+
+	if _, err := Create("data", "missing", nil, nil); err == nil {
+		t.Fatal("create with a missing driver succeeded")
+	}
+}
@@ -0,0 +1,420 @@
+package volume
+
+import (
+	"crypto/rand"
+	"docker/internal/utils/config"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	DefaultDriver = "local"
+
+	metaName = "volume.json"
+	dataName = "_data"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Volume is a named directory kept under the data root or provided by a
+// volume plugin, Containers records the containers which mount it.
+type Volume struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Mountpoint string            `json:"mountpoint"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Options    map[string]string `json:"options,omitempty"`
+	CreatedAt  string            `json:"createdAt"`
+	Containers []string          `json:"containers,omitempty"`
+	// MountedBy records the containers a plugin volume is mounted for, each
+	// is unmounted once when the container exits.
+	MountedBy []string `json:"mountedBy,omitempty"`
+}
+
+func volumePath(elem ...string) string {
+	return config.DataPath(append([]string{"volumes"}, elem...)...)
+}
+
+// IsName tells whether the source of -v is a volume name rather than a
+// host path.
+func IsName(src string) bool {
+	return validName.MatchString(src)
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (v *Volume) store() error {
+	content, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(volumePath(v.Name, metaName), content, 0644)
+}
+
+// Get loads the volume by name.
+func Get(name string) (*Volume, error) {
+	content, err := os.ReadFile(volumePath(name, metaName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("minidocker: no such volume %v", name)
+		}
+		return nil, err
+	}
+
+	var v Volume
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, fmt.Errorf("minidocker: parse volume %v failed [%v]", name, err)
+	}
+
+	return &v, nil
+}
+
+// plugin returns the volume plugin of the volume, nil for a local volume.
+func (v *Volume) plugin() (*plugin, error) {
+	if v.Driver == DefaultDriver {
+		return nil, nil
+	}
+
+	return getPlugin(v.Driver)
+}
+
+// listPluginVolumes adds the volumes the plugins report which were not
+// created by minidocker, an unreachable plugin is skipped.
+func listPluginVolumes(volumes []*Volume) []*Volume {
+	known := map[string]bool{}
+	for _, v := range volumes {
+		known[v.Name] = true
+	}
+
+	names, err := listPlugins()
+	if err != nil {
+		log.Warnf("list volume plugins failed: %v", err)
+		return volumes
+	}
+
+	for _, name := range names {
+		p, err := getPlugin(name)
+		if err != nil {
+			log.Warnf("skip volume plugin %v: %v", name, err)
+			continue
+		}
+
+		listed, err := p.List()
+		if err != nil {
+			log.Warnf("skip volume plugin %v: %v", name, err)
+			continue
+		}
+
+		for _, pv := range listed {
+			if !known[pv.Name] {
+				known[pv.Name] = true
+				volumes = append(volumes, &Volume{Name: pv.Name, Driver: name, Mountpoint: pv.Mountpoint})
+			}
+		}
+	}
+
+	return volumes
+}
+
+// listStored returns the volumes created by minidocker.
+func listStored() ([]*Volume, error) {
+	entries, err := os.ReadDir(volumePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var volumes []*Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := Get(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, v)
+	}
+
+	return volumes, nil
+}
+
+// List returns the volumes of all the drivers sorted by name.
+func List() ([]*Volume, error) {
+	volumes, err := listStored()
+	if err != nil {
+		return nil, err
+	}
+	volumes = listPluginVolumes(volumes)
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+
+	return volumes, nil
+}
+
+// Create creates a volume with the driver, an empty name creates an
+// anonymous volume with a random name and an empty driver is local.
+// Creating an existing volume returns it as is.
+func Create(name, driver string, labels, opts map[string]string) (*Volume, error) {
+	if name == "" {
+		var err error
+		if name, err = randomName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !IsName(name) {
+		return nil, fmt.Errorf("minidocker: invalid volume name %v, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+
+	if v, err := Get(name); err == nil {
+		if driver != "" && driver != v.Driver {
+			return nil, fmt.Errorf("minidocker: volume %v already exists with driver %v", name, v.Driver)
+		}
+		return v, nil
+	}
+
+	if driver == "" {
+		driver = DefaultDriver
+	}
+
+	v := &Volume{
+		Name:      name,
+		Driver:    driver,
+		Labels:    labels,
+		Options:   opts,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	p, err := v.plugin()
+	if err != nil {
+		return nil, err
+	}
+
+	if p == nil {
+		if len(opts) != 0 {
+			return nil, fmt.Errorf("minidocker: the %v volume driver takes no options", DefaultDriver)
+		}
+		v.Mountpoint = volumePath(name, dataName)
+		if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+			return nil, fmt.Errorf("minidocker: create volume %v failed [%v]", name, err)
+		}
+	} else {
+		if err := p.Create(name, opts); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(volumePath(name), 0755); err != nil {
+			p.Remove(name)
+			return nil, fmt.Errorf("minidocker: create volume %v failed [%v]", name, err)
+		}
+	}
+
+	if err := v.store(); err != nil {
+		v.destroy()
+		return nil, fmt.Errorf("minidocker: store volume %v failed [%v]", name, err)
+	}
+
+	return v, nil
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+func without(list []string, item string) []string {
+	var kept []string
+	for _, i := range list {
+		if i != item {
+			kept = append(kept, i)
+		}
+	}
+
+	return kept
+}
+
+// Acquire records that the container uses the volume, a missing volume is
+// created like docker run does. A plugin volume is mounted for the
+// container.
+func Acquire(name, container string) (*Volume, error) {
+	v, err := Create(name, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if contains(v.Containers, container) {
+		return v, nil
+	}
+
+	p, err := v.plugin()
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		if v.Mountpoint, err = p.Mount(name, container); err != nil {
+			return nil, err
+		}
+		v.MountedBy = append(v.MountedBy, container)
+	}
+
+	v.Containers = append(v.Containers, container)
+	if err := v.store(); err != nil {
+		if p != nil {
+			p.Unmount(name, container)
+		}
+		return nil, fmt.Errorf("minidocker: store volume %v failed [%v]", name, err)
+	}
+
+	return v, nil
+}
+
+// Unmount tells the plugin of the volume that the container exited, the
+// container keeps using the volume until it is released.
+func Unmount(name, container string) error {
+	v, err := Get(name)
+	if err != nil {
+		return err
+	}
+
+	if !contains(v.MountedBy, container) {
+		return nil
+	}
+
+	p, err := v.plugin()
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		if err := p.Unmount(name, container); err != nil {
+			return err
+		}
+	}
+	v.MountedBy = without(v.MountedBy, container)
+
+	return v.store()
+}
+
+// Release drops the container from the users of the volume, a plugin
+// volume still mounted for the container is unmounted.
+func Release(name, container string) error {
+	if err := Unmount(name, container); err != nil {
+		return err
+	}
+
+	v, err := Get(name)
+	if err != nil {
+		return err
+	}
+	v.Containers = without(v.Containers, container)
+
+	return v.store()
+}
+
+// destroy deletes the volume from its driver and its metadata.
+func (v *Volume) destroy() error {
+	p, err := v.plugin()
+	if err != nil {
+		return err
+	}
+
+	if p != nil {
+		if err := p.Remove(v.Name); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(volumePath(v.Name))
+}
+
+// Remove deletes the volume and its data, a volume in use is kept.
+func Remove(name string) error {
+	v, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	if len(v.Containers) != 0 {
+		return fmt.Errorf("minidocker: volume %v is in use by %v", name, strings.Join(v.Containers, ", "))
+	}
+
+	return v.destroy()
+}
+
+// find looks up the volume, including the volumes which only a plugin
+// knows about.
+func find(name string) (*Volume, error) {
+	v, err := Get(name)
+	if err == nil {
+		return v, nil
+	}
+
+	for _, pv := range listPluginVolumes(nil) {
+		if pv.Name == name {
+			return pv, nil
+		}
+	}
+
+	return nil, err
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size
+}
+
+// Prune removes the volumes created by minidocker which no container uses
+// and returns their names and the reclaimed space. The volumes a plugin
+// reports but minidocker did not create are left alone.
+func Prune() ([]string, int64, error) {
+	volumes, err := listStored()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var removed []string
+	var reclaimed int64
+	for _, v := range volumes {
+		if len(v.Containers) != 0 {
+			continue
+		}
+
+		var size int64
+		if v.Driver == DefaultDriver {
+			size = dirSize(v.Mountpoint)
+		}
+		if err := v.destroy(); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, v.Name)
+		reclaimed += size
+	}
+
+	return removed, reclaimed, nil
+}
@@ -0,0 +1,307 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+type ChangeKind int
+
+const (
+	ChangeModify ChangeKind = iota
+	ChangeAdd
+	ChangeDelete
+)
+
+func (kind ChangeKind) String() string {
+	switch kind {
+	case ChangeAdd:
+		return "A"
+	case ChangeDelete:
+		return "D"
+	}
+
+	return "C"
+}
+
+// MarshalText writes the kind as A, C or D.
+func (kind ChangeKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+// Change is a path of the rootfs which differs from the image, the path is
+// absolute inside the rootfs.
+type Change struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+}
+
+// lookupLower finds rel in the lowerdirs, the top layer first, like
+// overlayfs does. A whiteout or an opaque directory hides the layers below.
+func lookupLower(lowerdirs []string, rel string) (os.FileInfo, bool) {
+	parts := strings.Split(rel, "/")
+
+	for _, lowerdir := range lowerdirs {
+		hidden := false
+		// an ancestor which is a whiteout, a file or opaque hides rel below
+		for i := 1; i < len(parts); i++ {
+			info, err := os.Lstat(filepath.Join(lowerdir, filepath.Join(parts[:i]...)))
+			if err != nil {
+				continue
+			}
+			if isOverlayWhiteout(info) || !info.IsDir() {
+				return nil, false
+			}
+			if isOpaque(filepath.Join(lowerdir, filepath.Join(parts[:i]...))) {
+				hidden = true
+			}
+		}
+
+		info, err := os.Lstat(filepath.Join(lowerdir, rel))
+		if err == nil {
+			if isOverlayWhiteout(info) {
+				return nil, false
+			}
+			return info, true
+		}
+
+		if hidden {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+func statOf(info os.FileInfo) *syscall.Stat_t {
+	stat, _ := info.Sys().(*syscall.Stat_t)
+	return stat
+}
+
+// sameFile compares the metadata of a file of the rootfs and of the image,
+// the content is only compared when the sizes and times do not tell.
+func sameFile(file string, info os.FileInfo, lowerFile string, lower os.FileInfo) bool {
+	if info.Mode() != lower.Mode() {
+		return false
+	}
+
+	stat, lowerStat := statOf(info), statOf(lower)
+	if stat == nil || lowerStat == nil {
+		return false
+	}
+	if stat.Uid != lowerStat.Uid || stat.Gid != lowerStat.Gid || stat.Rdev != lowerStat.Rdev {
+		return false
+	}
+
+	if info.IsDir() {
+		return info.ModTime().Equal(lower.ModTime())
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, _ := os.Readlink(file)
+		lowerLink, _ := os.Readlink(lowerFile)
+		return link == lowerLink
+	}
+
+	if info.Size() != lower.Size() {
+		return false
+	}
+	if info.ModTime().Equal(lower.ModTime()) {
+		return true
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	lowerContent, err := os.ReadFile(lowerFile)
+	return err == nil && bytes.Equal(content, lowerContent)
+}
+
+// findLower returns the host path of rel in the first layer that has it.
+func findLower(lowerdirs []string, rel string) string {
+	for _, lowerdir := range lowerdirs {
+		if _, err := os.Lstat(filepath.Join(lowerdir, rel)); err == nil {
+			return filepath.Join(lowerdir, rel)
+		}
+	}
+
+	return ""
+}
+
+func sortChanges(changes []Change) []Change {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// ChangesDirs compares a full copy of the rootfs with the lowerdirs it was
+// copied from, the top layer first.
+func ChangesDirs(rootfs string, lowerdirs []string) ([]Change, error) {
+	var changes []Change
+
+	err := filepath.Walk(rootfs, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(rootfs, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		lower, exist := lookupLower(lowerdirs, rel)
+		if !exist {
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeAdd})
+		} else if !sameFile(file, info, findLower(lowerdirs, rel), lower) {
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeModify})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := map[string]bool{}
+	for _, lowerdir := range lowerdirs {
+		err := filepath.Walk(lowerdir, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(lowerdir, file)
+			if err != nil || rel == "." || deleted[rel] {
+				return err
+			}
+
+			if current, err := os.Lstat(filepath.Join(rootfs, rel)); err == nil {
+				// a directory replaced by a file is a change, the file
+				// hides the lower content without any whiteout
+				if info.IsDir() && !current.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// only the top of a deleted tree is reported
+			if _, visible := lookupLower(lowerdirs, rel); visible {
+				deleted[rel] = true
+				changes = append(changes, Change{Path: "/" + rel, Kind: ChangeDelete})
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sortChanges(changes), nil
+}
+
+// hiddenChildren returns the entries of the lower directory rel which an
+// opaque directory of the upper directory hides.
+func hiddenChildren(upperdir string, lowerdirs []string, rel string) []string {
+	seen := map[string]bool{}
+	var hidden []string
+	for _, lowerdir := range lowerdirs {
+		entries, err := os.ReadDir(filepath.Join(lowerdir, rel))
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			child := filepath.Join(rel, entry.Name())
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+
+			if _, err := os.Lstat(filepath.Join(upperdir, child)); err == nil {
+				continue
+			}
+			if _, visible := lookupLower(lowerdirs, child); visible {
+				hidden = append(hidden, child)
+			}
+		}
+	}
+
+	return hidden
+}
+
+// OverlayChanges lists the changes kept in an overlay upper directory, a
+// whiteout is a deletion and an entry hiding nothing in the lowerdirs is an
+// addition. The lower entries an opaque directory hides are deletions.
+func OverlayChanges(upperdir string, lowerdirs []string) ([]Change, error) {
+	var changes []Change
+
+	err := filepath.Walk(upperdir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(upperdir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		_, exist := lookupLower(lowerdirs, rel)
+		switch {
+		case isOverlayWhiteout(info):
+			if exist {
+				changes = append(changes, Change{Path: "/" + rel, Kind: ChangeDelete})
+			}
+		case exist:
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeModify})
+		default:
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeAdd})
+		}
+
+		if exist && info.IsDir() && isOpaque(file) {
+			for _, child := range hiddenChildren(upperdir, lowerdirs, rel) {
+				changes = append(changes, Change{Path: "/" + child, Kind: ChangeDelete})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sortChanges(changes), nil
+}
+
+// ExportChanges writes the changes of the rootfs as a layer tar stream,
+// deletions are written as .wh. entries.
+func ExportChanges(rootfs string, changes []Change, w io.Writer) error {
+	tw := newTarWriter(w, nil)
+
+	for _, change := range changes {
+		name := strings.TrimPrefix(change.Path, "/")
+		if change.Kind == ChangeDelete {
+			if err := tw.addWhiteout(name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		file := filepath.Join(rootfs, name)
+		info, err := os.Lstat(file)
+		if err != nil {
+			return err
+		}
+		if err := tw.addFile(file, name, info); err != nil {
+			return err
+		}
+	}
+
+	return tw.close()
+}
@@ -0,0 +1,212 @@
+package archive
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// writeFiles creates the files under dir, a name ending with a slash is a
+// directory.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(file, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// copyTree copies src into a new directory, keeping the metadata the change
+// detection compares.
+func copyTree(t *testing.T, src string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Tar(src, &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	if err := Untar(&buf, dest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return dest
+}
+
+func changeList(changes []Change) []string {
+	var list []string
+	for _, change := range changes {
+		list = append(list, change.Kind.String()+" "+change.Path)
+	}
+
+	return list
+}
+
+func TestChangesDirs(t *testing.T) {
+	lower := t.TempDir()
+	writeFiles(t, lower, map[string]string{
+		"etc/passwd":        "root",
+		"etc/hosts":         "localhost",
+		"etc/group":         "root",
+		"var/log/":          "",
+		"replaced/child":    "child",
+		"replaced/sub/file": "file",
+		"removed/child":     "child",
+		"kept/child":        "child",
+	})
+
+	// whole seconds, which the tar headers keep
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err := filepath.Walk(lower, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(file, past, past)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootfs := copyTree(t, lower)
+
+	// the same size and content with another time is no change
+	if err := os.Chtimes(filepath.Join(rootfs, "etc/hosts"), time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "etc/passwd"), []byte("user"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(rootfs, "etc/group"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, rootfs, map[string]string{"var/log/new": "new", "added/file": "file"})
+	for _, dir := range []string{"replaced", "removed"} {
+		if err := os.RemoveAll(filepath.Join(rootfs, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(rootfs, "replaced"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := ChangesDirs(rootfs, []string{lower})
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+
+	want := []string{
+		"A /added",
+		"A /added/file",
+		"C /etc/group",
+		"C /etc/passwd",
+		"D /removed",
+		"C /replaced",
+		"C /var/log",
+		"A /var/log/new",
+	}
+	if got := changeList(changes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportChanges(rootfs, changes, &buf); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	// no whiteout is written under the file which replaced a directory
+	want = []string{".wh.removed", "added/", "added/file", "etc/group", "etc/passwd", "replaced", "var/log/", "var/log/new"}
+	if got := tarNames(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestChangesDirsLayers(t *testing.T) {
+	base := t.TempDir()
+	writeFiles(t, base, map[string]string{"dir/old": "old", "dir/hidden": "hidden", "file": "file"})
+
+	// the top layer deletes dir/hidden and makes file a directory
+	top := t.TempDir()
+	writeFiles(t, top, map[string]string{"dir/new": "new", "file/": ""})
+	if err := unix.Mknod(filepath.Join(top, "dir/hidden"), unix.S_IFCHR, 0); err != nil {
+		t.Skipf("overlay whiteouts can not be created: %v", err)
+	}
+
+	rootfs := t.TempDir()
+	writeFiles(t, rootfs, map[string]string{"dir/old": "old", "dir/new": "new", "file/": ""})
+
+	changes, err := ChangesDirs(rootfs, []string{top, base})
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+
+	for _, change := range changes {
+		switch change.Path {
+		case "/dir/hidden":
+			t.Errorf("the deleted %v of the image is reported", change.Path)
+		case "/dir/old", "/dir/new", "/file":
+			if change.Kind != ChangeModify {
+				t.Errorf("%v is reported as %v, want C", change.Path, change.Kind)
+			}
+		}
+	}
+}
+
+func TestOverlayChanges(t *testing.T) {
+	lower := t.TempDir()
+	writeFiles(t, lower, map[string]string{
+		"etc/passwd":     "root",
+		"etc/removed":    "removed",
+		"opaque/old":     "old",
+		"replaced/child": "child",
+	})
+
+	upper := t.TempDir()
+	requireOverlayXattrs(t, upper)
+	writeFiles(t, upper, map[string]string{
+		"etc/passwd": "user",
+		"opaque/new": "new",
+		"replaced":   "file",
+		"added":      "added",
+	})
+	if err := unix.Mknod(filepath.Join(upper, "etc/removed"), unix.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Lsetxattr(filepath.Join(upper, "opaque"), opaqueXattr, []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := OverlayChanges(upper, []string{lower})
+	if err != nil {
+		t.Fatalf("changes failed: %v", err)
+	}
+
+	want := []string{
+		"A /added",
+		"C /etc",
+		"C /etc/passwd",
+		"D /etc/removed",
+		"C /opaque",
+		"A /opaque/new",
+		"D /opaque/old",
+		"C /replaced",
+	}
+	if got := changeList(changes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectCompression detects the compression by the magic of the stream.
+func DetectCompression(magic []byte) Compression {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return Zstd
+	}
+
+	return Uncompressed
+}
+
+type readCloser struct {
+	io.Reader
+	close func()
+}
+
+func (rc *readCloser) Close() error {
+	rc.close()
+	return nil
+}
+
+// DecompressStream returns the uncompressed content of a gzip, zstd or
+// uncompressed stream.
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch DetectCompression(magic) {
+	case Gzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return gr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &readCloser{Reader: zr, close: zr.Close}, nil
+	}
+
+	return io.NopCloser(br), nil
+}
@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const xattrPrefix = "SCHILY.xattr."
+
+// overlayXattrPrefix marks the xattrs overlayfs keeps for itself in the
+// upper directory, they are meaningless in an image layer.
+const overlayXattrPrefix = "trusted.overlay."
+
+type inode struct {
+	dev, ino uint64
+}
+
+// readXattrs returns the extended attributes of the file as PAX records.
+func readXattrs(file string) (map[string]string, error) {
+	size, err := unix.Llistxattr(file, nil)
+	if err != nil {
+		if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(file, buf); err != nil {
+		return nil, err
+	}
+
+	records := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, overlayXattrPrefix) {
+			continue
+		}
+
+		vsize, err := unix.Lgetxattr(file, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Lgetxattr(file, name, value); err != nil {
+			return nil, err
+		}
+		records[xattrPrefix+name] = string(value[:vsize])
+	}
+
+	return records, nil
+}
+
+// tarWriter writes files as tar entries, the later names of a file with
+// several links are written as hardlinks to the first one.
+type tarWriter struct {
+	tw      *tar.Writer
+	links   map[inode]string
+	options *Options
+}
+
+func newTarWriter(w io.Writer, options *Options) *tarWriter {
+	return &tarWriter{tw: tar.NewWriter(w), links: map[inode]string{}, options: options}
+}
+
+// addFile writes the file as the entry name.
+func (w *tarWriter) addFile(file, name string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name, hdr.Uname, hdr.Gname = name, "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			key := inode{uint64(stat.Dev), uint64(stat.Ino)}
+			if first, exist := w.links[key]; exist {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				w.links[key] = name
+			}
+		}
+	}
+
+	if w.options.overlayWhiteouts() && isOverlayWhiteout(info) {
+		return w.tw.WriteHeader(whiteoutHeader(hdr))
+	}
+
+	if hdr.PAXRecords, err = readXattrs(file); err != nil {
+		return fmt.Errorf("read xattrs of %v failed [%v]", name, err)
+	}
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if w.options.overlayWhiteouts() && info.IsDir() && isOpaque(file) {
+		return w.tw.WriteHeader(opaqueHeader(hdr))
+	}
+
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w.tw, f)
+	return err
+}
+
+// addWhiteout writes the .wh. entry which deletes name.
+func (w *tarWriter) addWhiteout(name string) error {
+	return w.tw.WriteHeader(whiteoutHeader(&tar.Header{Name: name, Mode: 0600, ModTime: time.Now()}))
+}
+
+func (w *tarWriter) close() error {
+	return w.tw.Close()
+}
+
+// Tar writes the content of dir as an uncompressed tar stream. Ownership,
+// xattrs, hardlinks and device nodes are kept, the names are relative to dir.
+func Tar(dir string, w io.Writer, options *Options) error {
+	return TarPath(dir, "", w, options)
+}
+
+// TarPath writes src, a file or a directory with its content, as the entry
+// name. An empty name writes the content of the directory src only.
+func TarPath(src, name string, w io.Writer, options *Options) error {
+	tw := newTarWriter(w, options)
+
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		entry := filepath.ToSlash(filepath.Join(name, rel))
+		if entry == "." {
+			return nil
+		}
+
+		return tw.addFile(file, entry, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.close()
+}
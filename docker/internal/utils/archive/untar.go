@@ -0,0 +1,285 @@
+package archive
+
+import (
+	"archive/tar"
+	upath "docker/internal/utils/path"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// cleanName returns the entry name relative to the destination, a name which
+// climbs out of it with ".." is rejected. Leading slashes are dropped as tar
+// does.
+func cleanName(name string) (string, error) {
+	clean := filepath.Clean(strings.TrimLeft(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("the entry %v escapes the destination", name)
+	}
+
+	if clean == "." {
+		return "", nil
+	}
+
+	return clean, nil
+}
+
+// resolve returns the host path of an entry. The parent directories are
+// resolved inside dest, or the scope of the options, so that symlinks of
+// the archive, absolute or relative, can not redirect the entry out of it.
+func (options *Options) resolve(dest, name string) (string, error) {
+	var parent string
+	var err error
+	if options != nil && options.Scope != nil {
+		parent, err = options.Scope(filepath.Dir(name))
+	} else {
+		parent, err = upath.FollowSymlinkInScope(dest, filepath.Dir(name))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
+
+// setXattrs restores the xattrs of the entry. The xattrs of overlayfs are
+// never restored, a layer could otherwise plant opaque or redirect marks in
+// a lowerdir. The security and trusted xattrs are skipped where the
+// filesystem or the privileges do not allow them, as docker does.
+func setXattrs(target string, hdr *tar.Header) error {
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(key, xattrPrefix)
+		if strings.HasPrefix(name, overlayXattrPrefix) {
+			continue
+		}
+
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil {
+			optional := strings.HasPrefix(name, "security.") || strings.HasPrefix(name, "trusted.")
+			if optional && (err == unix.ENOTSUP || err == unix.EPERM) {
+				continue
+			}
+			return fmt.Errorf("set xattr %v of %v failed [%v]", key, hdr.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func setTimes(target string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func createEntry(dest, target string, hdr *tar.Header, r io.Reader, options *Options) error {
+	mode := uint32(hdr.Mode & 07777)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			if err := os.Mkdir(target, os.FileMode(mode)); err != nil {
+				return err
+			}
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, r); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		name, err := cleanName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		source, err := options.resolve(dest, name)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Lstat(source); err != nil || info.IsDir() {
+			return fmt.Errorf("the hardlink %v points to an invalid entry %v", hdr.Name, hdr.Linkname)
+		}
+		return os.Link(source, target)
+	case tar.TypeSymlink:
+		// the link is resolved inside the container rootfs, it is only a
+		// concern while extracting, which resolve takes care of
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		kind := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			kind = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			kind = unix.S_IFBLK
+		}
+		if err := unix.Mknod(target, kind|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("the entry %v has the unsupported type %c", hdr.Name, hdr.Typeflag)
+	}
+
+	return nil
+}
+
+// Untar extracts an uncompressed tar stream into dest. Ownership, modes,
+// xattrs, hardlinks and device nodes are restored, entries which would be
+// written outside dest are rejected.
+func Untar(r io.Reader, dest string, options *Options) error {
+	dest = filepath.Clean(dest)
+	tr := tar.NewReader(r)
+
+	// the times of directories are set last as their entries change them
+	var dirs []*tar.Header
+	unpacked := map[string]bool{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar failed [%v]", err)
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		name, err := cleanName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		hdr.Name = name
+
+		target, err := options.resolve(dest, name)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		if options.overlayWhiteouts() {
+			if whiteout, err := applyWhiteout(target); whiteout {
+				if err != nil {
+					return fmt.Errorf("apply whiteout %v failed [%v]", hdr.Name, err)
+				}
+				continue
+			}
+		}
+
+		if options.applyWhiteouts() {
+			if whiteout, err := removeWhiteout(target, unpacked); whiteout {
+				if err != nil {
+					return fmt.Errorf("apply whiteout %v failed [%v]", hdr.Name, err)
+				}
+				continue
+			}
+		}
+		unpacked[target] = true
+
+		// an existing entry is replaced unless both are directories
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		if err := createEntry(dest, target, hdr, tr, options); err != nil {
+			return fmt.Errorf("extract %v failed [%v]", hdr.Name, err)
+		}
+
+		if hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return fmt.Errorf("chown %v failed [%v]", hdr.Name, err)
+		}
+
+		if err := setXattrs(target, hdr); err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeSymlink {
+			// chmod after chown, which clears the setuid and setgid bits
+			if err := unix.Chmod(target, uint32(hdr.Mode&07777)); err != nil {
+				return fmt.Errorf("chmod %v failed [%v]", hdr.Name, err)
+			}
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, &tar.Header{Name: target, ModTime: hdr.ModTime, AccessTime: hdr.AccessTime})
+			continue
+		}
+
+		if err := setTimes(target, hdr); err != nil {
+			return fmt.Errorf("set times of %v failed [%v]", hdr.Name, err)
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := setTimes(dir.Name, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UntarFile extracts a gzip, zstd or uncompressed tarball into dest.
+func UntarFile(file, dest string, options *Options) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := DecompressStream(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return Untar(r, dest, options)
+}
+
+// IsArchive tells whether the file is a tarball, compressed or not.
+func IsArchive(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	r, err := DecompressStream(f)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	_, err = tar.NewReader(r).Next()
+	return err == nil
+}
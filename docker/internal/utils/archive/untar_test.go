@@ -0,0 +1,194 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// buildTar writes the headers as a tar stream, the content of a regular
+// file is taken from contents.
+func buildTar(t *testing.T, headers []*tar.Header, contents map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		content := contents[hdr.Name]
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  bool
+	}{
+		{name: "etc/passwd", want: "etc/passwd"},
+		{name: "/etc/passwd", want: "etc/passwd"},
+		{name: "./etc/../etc/passwd", want: "etc/passwd"},
+		{name: "a/../../b", err: true},
+		{name: "..", err: true},
+		{name: "../etc/passwd", err: true},
+		{name: "/../etc/passwd", err: true},
+		{name: "./", want: ""},
+		{name: "..foo", want: "..foo"},
+	}
+
+	for _, test := range tests {
+		got, err := cleanName(test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("cleanName(%q) = %q, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("cleanName(%q) = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestUntarTraversal(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  []*tar.Header
+		contents map[string]string
+		// err tells whether the archive is rejected, otherwise the entries
+		// must end up inside the destination
+		err bool
+	}{
+		{
+			name:    "dotdot",
+			headers: []*tar.Header{{Name: "../pwned", Typeflag: tar.TypeReg, Mode: 0644}},
+			err:     true,
+		},
+		{
+			name:    "nested dotdot",
+			headers: []*tar.Header{{Name: "a/../../pwned", Typeflag: tar.TypeReg, Mode: 0644}},
+			err:     true,
+		},
+		{
+			name:    "absolute",
+			headers: []*tar.Header{{Name: "/pwned", Typeflag: tar.TypeReg, Mode: 0644}},
+		},
+		{
+			name: "symlink to parent",
+			headers: []*tar.Header{
+				{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+				{Name: "up/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			name: "absolute symlink",
+			headers: []*tar.Header{
+				{Name: "root", Typeflag: tar.TypeSymlink, Linkname: "/", Mode: 0777},
+				{Name: "root/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			name: "chained symlinks",
+			headers: []*tar.Header{
+				{Name: "a", Typeflag: tar.TypeSymlink, Linkname: ".", Mode: 0777},
+				{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+				{Name: "b/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			name:    "hardlink out",
+			headers: []*tar.Header{{Name: "pwned", Typeflag: tar.TypeLink, Linkname: "../secret"}},
+			err:     true,
+		},
+		{
+			name: "hardlink through symlink",
+			headers: []*tar.Header{
+				{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+				{Name: "pwned", Typeflag: tar.TypeLink, Linkname: "up/secret"},
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "dest")
+			if err := os.Mkdir(dest, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Untar(buildTar(t, test.headers, test.contents), dest, nil)
+			if test.err && err == nil {
+				t.Fatal("the archive was extracted")
+			}
+			if !test.err && err != nil {
+				t.Fatalf("extract failed: %v", err)
+			}
+
+			if _, err := os.Lstat(filepath.Join(parent, "pwned")); err == nil {
+				t.Fatal("an entry was written outside the destination")
+			}
+			if !test.err {
+				if _, err := os.Lstat(filepath.Join(dest, "pwned")); err != nil {
+					t.Fatalf("the entry is not kept inside the destination: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUntarSkipsOverlayXattrs(t *testing.T) {
+	dest := t.TempDir()
+	archive := buildTar(t, []*tar.Header{{
+		Name:     "dir/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+		PAXRecords: map[string]string{
+			xattrPrefix + opaqueXattr:                "y",
+			xattrPrefix + "trusted.overlay.redirect": "/etc",
+			xattrPrefix + "user.kept":                "value",
+		},
+	}}, nil)
+
+	if err := Untar(archive, dest, nil); err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	dir := filepath.Join(dest, "dir")
+	if isOpaque(dir) {
+		t.Fatal("the opaque xattr of the archive was restored")
+	}
+	if _, err := unix.Lgetxattr(dir, "trusted.overlay.redirect", make([]byte, 16)); err == nil {
+		t.Fatal("the redirect xattr of the archive was restored")
+	}
+
+	value := make([]byte, 16)
+	n, err := unix.Lgetxattr(dir, "user.kept", value)
+	if err != nil {
+		t.Skipf("user xattrs are not supported: %v", err)
+	}
+	if string(value[:n]) != "value" {
+		t.Fatalf("user.kept = %q, want value", value[:n])
+	}
+}
@@ -0,0 +1,146 @@
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// WhiteoutPrefix marks a file deleted in the layer, .wh.name hides name
+	// of the lower layers.
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir hides all the content of the lower layers in its
+	// directory.
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+
+	opaqueXattr = "trusted.overlay.opaque"
+)
+
+// Options changes how the layer content is translated and extracted.
+type Options struct {
+	// OverlayWhiteouts translates between the whiteouts of overlayfs, 0/0
+	// character devices and the opaque xattr, and the .wh. files of the
+	// image layers.
+	OverlayWhiteouts bool
+	// ApplyWhiteouts deletes the files hidden by the .wh. entries, which
+	// applies a layer onto a full copy of the lower layers.
+	ApplyWhiteouts bool
+	// Scope resolves a directory of the archive, relative to the
+	// destination, to the host. By default the symlinks are resolved
+	// inside the destination, a scope lets them resolve inside a larger
+	// tree such as the rootfs of a container.
+	Scope func(dir string) (string, error)
+}
+
+func (options *Options) overlayWhiteouts() bool {
+	return options != nil && options.OverlayWhiteouts
+}
+
+func (options *Options) applyWhiteouts() bool {
+	return options != nil && options.ApplyWhiteouts
+}
+
+// isOverlayWhiteout tells whether the file is a 0/0 character device which
+// overlayfs leaves in the upper directory for a deleted file.
+func isOverlayWhiteout(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0
+}
+
+// whiteoutHeader is the .wh. entry which replaces an overlay whiteout.
+func whiteoutHeader(hdr *tar.Header) *tar.Header {
+	dir, base := path.Split(hdr.Name)
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     dir + WhiteoutPrefix + base,
+		Mode:     hdr.Mode & 0777,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		ModTime:  hdr.ModTime,
+	}
+}
+
+// isOpaque tells whether overlayfs marked the directory as opaque, it then
+// hides the directory of the lower layers.
+func isOpaque(dir string) bool {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(dir, opaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// opaqueHeader is the .wh..wh..opq entry of an opaque directory.
+func opaqueHeader(hdr *tar.Header) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     hdr.Name + WhiteoutOpaqueDir,
+		Mode:     hdr.Mode & 0777,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		ModTime:  hdr.ModTime,
+	}
+}
+
+// applyWhiteout turns a .wh. entry into its overlayfs form, it returns false
+// for the entries which are not whiteouts.
+func applyWhiteout(target string) (bool, error) {
+	dir, base := filepath.Split(target)
+	if !strings.HasPrefix(base, WhiteoutPrefix) {
+		return false, nil
+	}
+
+	if base == WhiteoutOpaqueDir {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return true, err
+		}
+		return true, unix.Lsetxattr(dir, opaqueXattr, []byte("y"), 0)
+	}
+
+	// other .wh..wh. entries are metadata of aufs, such as hardlink dirs
+	if strings.HasPrefix(base, WhiteoutPrefix+WhiteoutPrefix) {
+		return true, nil
+	}
+
+	original := filepath.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix))
+	if err := os.RemoveAll(original); err != nil {
+		return true, err
+	}
+
+	return true, unix.Mknod(original, unix.S_IFCHR, 0)
+}
+
+// removeWhiteout deletes the files a .wh. entry hides, an opaque directory
+// keeps only the entries unpacked from the same layer.
+func removeWhiteout(target string, unpacked map[string]bool) (bool, error) {
+	dir, base := filepath.Split(target)
+	if !strings.HasPrefix(base, WhiteoutPrefix) {
+		return false, nil
+	}
+
+	if base == WhiteoutOpaqueDir {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return true, err
+		}
+		for _, entry := range entries {
+			child := filepath.Join(dir, entry.Name())
+			if !unpacked[child] {
+				if err := os.RemoveAll(child); err != nil {
+					return true, err
+				}
+			}
+		}
+		return true, nil
+	}
+
+	if strings.HasPrefix(base, WhiteoutPrefix+WhiteoutPrefix) {
+		return true, nil
+	}
+
+	return true, os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix)))
+}
@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// tarNames lists the entry names of a tar stream, sorted.
+func tarNames(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// requireOverlayXattrs skips the test when the trusted xattrs of overlayfs
+// can not be set, which needs CAP_SYS_ADMIN.
+func requireOverlayXattrs(t *testing.T, dir string) {
+	t.Helper()
+
+	if err := unix.Lsetxattr(dir, opaqueXattr, []byte("y"), 0); err != nil {
+		t.Skipf("trusted xattrs are not supported: %v", err)
+	}
+	unix.Lremovexattr(dir, opaqueXattr)
+}
+
+func TestOverlayWhiteoutsRoundTrip(t *testing.T) {
+	upper := t.TempDir()
+	requireOverlayXattrs(t, upper)
+
+	layer := buildTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/.wh.passwd", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "opaque/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opaque/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "opaque/kept", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: ".wh..wh.plnk", Typeflag: tar.TypeReg, Mode: 0600},
+	}, map[string]string{"opaque/kept": "kept"})
+
+	options := &Options{OverlayWhiteouts: true}
+	if err := Untar(layer, upper, options); err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(upper, "etc/passwd"))
+	if err != nil || !isOverlayWhiteout(info) {
+		t.Fatalf("etc/passwd is not an overlay whiteout: %v", err)
+	}
+	if !isOpaque(filepath.Join(upper, "opaque")) {
+		t.Fatal("opaque is not marked opaque")
+	}
+	for _, name := range []string{"etc/.wh.passwd", "opaque/.wh..wh..opq", ".wh..wh.plnk"} {
+		if _, err := os.Lstat(filepath.Join(upper, name)); err == nil {
+			t.Errorf("the whiteout %v was extracted as a file", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Tar(upper, &buf, options); err != nil {
+		t.Fatalf("tar failed: %v", err)
+	}
+
+	want := []string{"etc/", "etc/.wh.passwd", "opaque/", "opaque/.wh..wh..opq", "opaque/kept"}
+	if got := tarNames(t, &buf); !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestApplyWhiteouts(t *testing.T) {
+	rootfs := t.TempDir()
+	for _, name := range []string{"etc/passwd", "etc/group", "opaque/old", "opaque/sub/old"} {
+		file := filepath.Join(rootfs, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	layer := buildTar(t, []*tar.Header{
+		{Name: "etc/.wh.passwd", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "opaque/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opaque/new", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "opaque/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0600},
+	}, map[string]string{"opaque/new": "new"})
+
+	if err := Untar(layer, rootfs, &Options{ApplyWhiteouts: true}); err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	tests := map[string]bool{
+		"etc/passwd":          false,
+		"etc/.wh.passwd":      false,
+		"etc/group":           true,
+		"opaque/old":          false,
+		"opaque/sub":          false,
+		"opaque/new":          true,
+		"opaque/.wh..wh..opq": false,
+	}
+	for name, exist := range tests {
+		_, err := os.Lstat(filepath.Join(rootfs, name))
+		if exist && err != nil {
+			t.Errorf("%v was removed: %v", name, err)
+		}
+		if !exist && err == nil {
+			t.Errorf("%v is kept", name)
+		}
+	}
+}
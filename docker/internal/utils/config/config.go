@@ -0,0 +1,176 @@
+package config
+
+import (
+	"docker/internal/utils/path"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DefaultRoot       = "/var/run/minidocker"
+	DefaultDataRoot   = "/var/lib/minidocker"
+	DefaultConfigFile = "/etc/minidocker/config.json"
+)
+
+// Config keeps the global settings of minidocker. Root holds the volatile
+// runtime state (container state, networks) and DataRoot holds the data
+// which should survive a reboot (images, layers, volumes).
+type Config struct {
+	Root               string                `json:"root,omitempty"`
+	DataRoot           string                `json:"data-root,omitempty"`
+	StorageDriver      string                `json:"storage-driver,omitempty"`
+	InsecureRegistries []string              `json:"insecure-registries,omitempty"`
+	Auths              map[string]AuthConfig `json:"auths,omitempty"`
+}
+
+// AuthConfig keeps the registry credentials, Auth is the base64 encoded
+// "username:password" as written by docker login.
+type AuthConfig struct {
+	Auth     string `json:"auth,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+var (
+	current = &Config{
+		Root:     DefaultRoot,
+		DataRoot: DefaultDataRoot,
+	}
+	configFile = DefaultConfigFile
+)
+
+// Load reads the config file, a missing config file keeps the defaults.
+func Load(file string) error {
+	configFile = file
+
+	exist, err := path.PathExist(file)
+	if err != nil {
+		return fmt.Errorf("minidocker: check config file %v failed [%v]", file, err)
+	}
+	if !exist {
+		return nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("minidocker: read config file %v failed [%v]", file, err)
+	}
+
+	if err := json.Unmarshal(content, current); err != nil {
+		return fmt.Errorf("minidocker: parse config file %v failed [%v]", file, err)
+	}
+
+	return nil
+}
+
+func SetRoot(root string) {
+	if root != "" {
+		current.Root = root
+	}
+}
+
+func SetDataRoot(dataRoot string) {
+	if dataRoot != "" {
+		current.DataRoot = dataRoot
+	}
+}
+
+// SetStorageDriver chooses the storage driver of new containers, overlay is
+// probed when none is set.
+func SetStorageDriver(driver string) {
+	if driver != "" {
+		current.StorageDriver = driver
+	}
+}
+
+func Get() *Config {
+	return current
+}
+
+// RootPath joins elem under the runtime root.
+func RootPath(elem ...string) string {
+	return filepath.Join(append([]string{current.Root}, elem...)...)
+}
+
+// DataPath joins elem under the data root.
+func DataPath(elem ...string) string {
+	return filepath.Join(append([]string{current.DataRoot}, elem...)...)
+}
+
+// IsInsecureRegistry tells whether the registry is served over plain HTTP,
+// loopback registries are always taken as insecure.
+func IsInsecureRegistry(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	if ip := net.ParseIP(hostname); (ip != nil && ip.IsLoopback()) || hostname == "localhost" {
+		return true
+	}
+
+	for _, registry := range current.InsecureRegistries {
+		if registry == host || registry == hostname {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Credentials returns the username and password stored for the registry.
+func Credentials(host string) (string, string) {
+	auth, exist := current.Auths[host]
+	if !exist {
+		return "", ""
+	}
+
+	if auth.Auth != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+			if username, password, ok := strings.Cut(string(decoded), ":"); ok {
+				return username, password
+			}
+		}
+	}
+
+	return auth.Username, auth.Password
+}
+
+// SaveCredentials stores the registry credentials into the config file, the
+// rest of the file is kept as is rather than replaced by the flags.
+func SaveCredentials(host, username, password string) error {
+	saved := &Config{}
+	if content, err := os.ReadFile(configFile); err == nil {
+		if err := json.Unmarshal(content, saved); err != nil {
+			return fmt.Errorf("minidocker: parse config file %v failed [%v]", configFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	auth := AuthConfig{
+		Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+	}
+	for _, c := range []*Config{saved, current} {
+		if c.Auths == nil {
+			c.Auths = map[string]AuthConfig{}
+		}
+		c.Auths[host] = auth
+	}
+
+	content, err := json.MarshalIndent(saved, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(configFile, content, 0600)
+}
@@ -1,7 +1,10 @@
 package path
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func PathExist(path string) (bool, error) {
@@ -16,3 +19,78 @@ func PathExist(path string) (bool, error) {
 
 	return false, err
 }
+
+// FollowSymlinkInScope resolves the symlinks of unsafePath as if root were
+// "/", so the result never leaves root. Missing components are joined as is.
+func FollowSymlinkInScope(root, unsafePath string) (string, error) {
+	root = filepath.Clean(root)
+	resolved, err := FollowSymlink(func(p string) string {
+		return filepath.Join(root, p)
+	}, unsafePath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(root, resolved), nil
+}
+
+// FollowSymlink resolves the symlinks of unsafePath in a tree whose paths
+// hostPath maps to the host, the result is the resolved path inside the
+// tree. It lets a tree made of several host directories, such as a rootfs
+// with its mounts, be resolved as a whole.
+func FollowSymlink(hostPath func(string) string, unsafePath string) (string, error) {
+	resolved := "/"
+	remaining := filepath.Clean("/" + unsafePath)
+
+	for links := 0; remaining != ""; {
+		remaining = strings.TrimPrefix(remaining, "/")
+		if remaining == "" {
+			break
+		}
+
+		part := remaining
+		if i := strings.IndexByte(remaining, '/'); i >= 0 {
+			part, remaining = remaining[:i], remaining[i:]
+		} else {
+			remaining = ""
+		}
+
+		switch part {
+		case ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		info, err := os.Lstat(hostPath(next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many symlinks in %v", unsafePath)
+		}
+
+		dest, err := os.Readlink(hostPath(next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			resolved = "/"
+		}
+		remaining = dest + "/" + remaining
+	}
+
+	return resolved, nil
+}